@@ -0,0 +1,562 @@
+package iosupport
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// This CompressedFile provides a FileReader on the uncompressed content of a gzip or a zstd file.
+// Main usages:
+//
+// file, _ := os.Open("my_file.tsv.gz")
+// cf, _ := iosupport.NewCompressedFile(file)
+// sc := iosupport.NewScanner(cf)
+//
+// // Store the index next to the file for the next usages
+// idxfile, _ := os.Create("my_file.tsv.gz.idx")
+// cf.Index().WriteTo(idxfile)
+//
+// idxfile, _ := os.Open("my_file.tsv.gz.idx")
+// index, _ := iosupport.ReadCheckpointIndex(idxfile)
+// cf, _ := iosupport.NewCompressedFileWithIndex(file, index)
+//
+// The decompression is resumed from checkpoints located at the beginning of the gzip members or zstd frames
+// and, inside the gzip members, every DefaultCheckpointInterval uncompressed bytes. The checkpoints inside a gzip
+// member hold the last 32 KiB of uncompressed data (the deflate window), so a random access (ReadAt, Seek)
+// decompresses at most an interval of data on any gzip file.
+// The zstd frames cannot be resumed from a window (their decoder state is larger than the history), so the random
+// accesses are only fast on zstd files made of several frames (e.g. pzstd or the zstd seekable format). On a single
+// frame zstd file, a backward random access decompresses the file from its beginning.
+
+// A CompressionFormat is the compression algorithm of a CompressedFile.
+type CompressionFormat byte
+
+const (
+	// Gzip compression format (RFC 1952).
+	Gzip CompressionFormat = iota + 1
+	// Zstd compression format (RFC 8878).
+	Zstd
+)
+
+var (
+	// ErrUnknownCompression -> the compression format is not supported
+	ErrUnknownCompression = errors.New("unknown compression format")
+	// ErrInvalidIndex -> the checkpoint index cannot be read
+	ErrInvalidIndex = errors.New("invalid checkpoint index")
+
+	gzipMagic       = []byte{0x1f, 0x8b}
+	zstdMagic       = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	checkpointMagic = []byte("IOCI")
+)
+
+const (
+	zstdSkippableMagicMask uint32 = 0xFFFFFFF0
+	zstdSkippableMagic     uint32 = 0x184D2A50
+	checkpointVersion      byte   = 2
+)
+
+type (
+	// A Checkpoint is a position of the compressed file from where the decompression can be resumed.
+	Checkpoint struct {
+		Offset           uint64 // Offset in the uncompressed content.
+		CompressedOffset uint64 // Offset in the compressed file.
+		// Inside a gzip member, the deflate block starts after the Bits first bits of the byte at CompressedOffset.
+		Bits uint8
+		// Inside a gzip member, the last 32 KiB of uncompressed data of the member (nil at the start of a member or frame).
+		Window []byte
+		// Inside a gzip member, the offset in the compressed file of the next member.
+		NextMember uint64
+	}
+
+	// A CheckpointIndex maps the uncompressed offsets to the compressed file.
+	CheckpointIndex struct {
+		Format      CompressionFormat
+		Size        uint64 // Size of the uncompressed content.
+		Checkpoints []Checkpoint
+	}
+
+	// A CompressedFile reads the uncompressed content of a compressed file.
+	CompressedFile struct {
+		sync.Mutex
+		f      FileReader       // The compressed file provided by the client.
+		index  *CheckpointIndex // Checkpoints of the compressed file.
+		offset int64            // Current offset used by Read and Seek.
+		stream io.ReadCloser    // Current decompression stream.
+		spos   uint64           // Uncompressed offset of the next byte read from the stream.
+	}
+)
+
+// DetectCompression returns the compression format of the given file.
+func DetectCompression(f FileReader) (CompressionFormat, error) {
+	magic := make([]byte, 4)
+	n, err := f.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return Gzip, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return Zstd, nil
+	case len(magic) == 4 && binary.LittleEndian.Uint32(magic)&zstdSkippableMagicMask == zstdSkippableMagic:
+		return Zstd, nil
+	}
+	return 0, ErrUnknownCompression
+}
+
+// NewCompressedFile instanciates a new CompressedFile and builds its checkpoint index.
+func NewCompressedFile(f FileReader) (*CompressedFile, error) {
+	index, err := BuildCheckpointIndex(f, 0)
+	if err != nil {
+		return nil, err
+	}
+	return NewCompressedFileWithIndex(f, index), nil
+}
+
+// NewCompressedFileWithIndex instanciates a new CompressedFile with an already built checkpoint index.
+func NewCompressedFileWithIndex(f FileReader, index *CheckpointIndex) *CompressedFile {
+	return &CompressedFile{
+		f:     f,
+		index: index,
+	}
+}
+
+// Index returns the checkpoint index of the file.
+func (cf *CompressedFile) Index() *CheckpointIndex {
+	return cf.index
+}
+
+// Size returns the size of the uncompressed content.
+func (cf *CompressedFile) Size() int64 {
+	return int64(cf.index.Size)
+}
+
+// Name returns the name of the compressed file.
+func (cf *CompressedFile) Name() string {
+	return cf.f.Name()
+}
+
+// Close closes the compressed file.
+func (cf *CompressedFile) Close() error {
+	cf.Lock()
+	defer cf.Unlock()
+
+	cf.closeStream()
+	return cf.f.Close()
+}
+
+// Read reads up to len(b) uncompressed bytes.
+func (cf *CompressedFile) Read(b []byte) (int, error) {
+	cf.Lock()
+	defer cf.Unlock()
+
+	n, err := cf.readAt(b, cf.offset)
+	cf.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads len(b) uncompressed bytes starting at the uncompressed offset off.
+// ReadAt always returns a non-nil error when n < len(b).
+func (cf *CompressedFile) ReadAt(b []byte, off int64) (int, error) {
+	cf.Lock()
+	defer cf.Unlock()
+
+	return cf.readAt(b, off)
+}
+
+// Seek sets the uncompressed offset for the next Read.
+func (cf *CompressedFile) Seek(offset int64, whence int) (int64, error) {
+	cf.Lock()
+	defer cf.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += cf.offset
+	case io.SeekEnd:
+		offset += int64(cf.index.Size)
+	default:
+		return 0, errors.New("CompressedFile: Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("CompressedFile: Seek: negative position")
+	}
+
+	cf.offset = offset
+	return offset, nil
+}
+
+func (cf *CompressedFile) readAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("CompressedFile: ReadAt: negative offset")
+	}
+	if uint64(off) >= cf.index.Size {
+		return 0, io.EOF
+	}
+
+	if err := cf.moveStream(uint64(off)); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(cf.stream, b)
+	cf.spos += uint64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// moveStream positions the decompression stream at the given uncompressed offset.
+func (cf *CompressedFile) moveStream(off uint64) error {
+	cp := cf.index.checkpoint(off)
+	if cf.stream == nil || off < cf.spos || cp.Offset > cf.spos {
+		// Resuming from the checkpoint is faster than reading the current stream
+		if err := cf.openStream(cp); err != nil {
+			return err
+		}
+	}
+
+	n, err := io.CopyN(ioutil.Discard, cf.stream, int64(off-cf.spos))
+	cf.spos += uint64(n)
+	return err
+}
+
+func (cf *CompressedFile) openStream(cp Checkpoint) error {
+	cf.closeStream()
+
+	r := bufio.NewReader(io.NewSectionReader(cf.f, int64(cp.CompressedOffset), 1<<62))
+	switch {
+	case cf.index.Format == Gzip && cp.Window != nil:
+		stream, err := openGzipCheckpoint(cf.f, cp)
+		if err != nil {
+			return fmt.Errorf("CompressedFile: %s", err)
+		}
+		cf.stream = stream
+	case cf.index.Format == Gzip:
+		stream, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("CompressedFile: %s", err)
+		}
+		cf.stream = stream
+	case cf.index.Format == Zstd:
+		stream, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("CompressedFile: %s", err)
+		}
+		cf.stream = stream.IOReadCloser()
+	default:
+		return ErrUnknownCompression
+	}
+
+	cf.spos = cp.Offset
+	return nil
+}
+
+func (cf *CompressedFile) closeStream() {
+	if cf.stream != nil {
+		cf.stream.Close()
+		cf.stream = nil
+	}
+}
+
+// ------------------ //
+// Index stuff        //
+// ------------------ //
+
+// BuildCheckpointIndex decompresses the whole file and records a checkpoint at each gzip member or zstd frame,
+// and every interval uncompressed bytes inside the gzip members (DefaultCheckpointInterval when interval is 0).
+// interval is the minimum number of uncompressed bytes between two checkpoints (0 keeps all the members and frames).
+func BuildCheckpointIndex(f FileReader, interval uint64) (*CheckpointIndex, error) {
+	format, err := DetectCompression(f)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &CheckpointIndex{Format: format}
+	appendCheckpoint := func(offset, compressedOffset uint64) {
+		l := len(index.Checkpoints)
+		if l > 0 && offset-index.Checkpoints[l-1].Offset < interval {
+			return
+		}
+		index.Checkpoints = append(index.Checkpoints, Checkpoint{Offset: offset, CompressedOffset: compressedOffset})
+	}
+
+	switch format {
+	case Gzip:
+		err = buildGzipCheckpoints(f, index, interval, appendCheckpoint)
+	case Zstd:
+		err = buildZstdCheckpoints(f, index, appendCheckpoint)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("BuildCheckpointIndex: %s", err)
+	}
+
+	return index, nil
+}
+
+// ReadCheckpointIndex reads an index written by CheckpointIndex.WriteTo.
+func ReadCheckpointIndex(r io.Reader) (*CheckpointIndex, error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(checkpointMagic)+2)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	version := header[len(checkpointMagic)]
+	if !bytes.HasPrefix(header, checkpointMagic) || version < 1 || version > checkpointVersion {
+		return nil, ErrInvalidIndex
+	}
+
+	index := &CheckpointIndex{Format: CompressionFormat(header[len(header)-1])}
+	var err error
+	if index.Size, err = binary.ReadUvarint(br); err != nil {
+		return nil, ErrInvalidIndex
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, ErrInvalidIndex
+	}
+
+	var cp Checkpoint
+	index.Checkpoints = make([]Checkpoint, 0, n)
+	for i := uint64(0); i < n; i++ {
+		// Offsets are delta encoded
+		delta, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, ErrInvalidIndex
+		}
+		cp.Offset += delta
+		if delta, err = binary.ReadUvarint(br); err != nil {
+			return nil, ErrInvalidIndex
+		}
+		cp.CompressedOffset += delta
+		if version > 1 {
+			if err := readWindow(br, &cp); err != nil {
+				return nil, ErrInvalidIndex
+			}
+		}
+		index.Checkpoints = append(index.Checkpoints, cp)
+	}
+
+	return index, nil
+}
+
+// WriteTo writes the index in a compact binary format.
+func (index *CheckpointIndex) WriteTo(w io.Writer) (int64, error) {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(checkpointMagic)
+	buf.WriteByte(checkpointVersion)
+	buf.WriteByte(byte(index.Format))
+
+	varint := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v uint64) {
+		buf.Write(varint[:binary.PutUvarint(varint, v)])
+	}
+	putUvarint(index.Size)
+	putUvarint(uint64(len(index.Checkpoints)))
+
+	var previous Checkpoint
+	for _, cp := range index.Checkpoints {
+		putUvarint(cp.Offset - previous.Offset)
+		putUvarint(cp.CompressedOffset - previous.CompressedOffset)
+		putUvarint(uint64(len(cp.Window)))
+		if len(cp.Window) > 0 {
+			buf.WriteByte(cp.Bits)
+			putUvarint(cp.NextMember - cp.CompressedOffset)
+			buf.Write(cp.Window)
+		}
+		previous = cp
+	}
+
+	return buf.WriteTo(w)
+}
+
+// readWindow reads the window of a checkpoint written by CheckpointIndex.WriteTo.
+func readWindow(br *bufio.Reader, cp *Checkpoint) error {
+	n, err := binary.ReadUvarint(br)
+	if err != nil || n > windowSize {
+		return ErrInvalidIndex
+	}
+	cp.Bits, cp.Window, cp.NextMember = 0, nil, 0
+	if n == 0 {
+		return nil
+	}
+
+	if cp.Bits, err = br.ReadByte(); err != nil || cp.Bits > 7 {
+		return ErrInvalidIndex
+	}
+	delta, err := binary.ReadUvarint(br)
+	if err != nil {
+		return ErrInvalidIndex
+	}
+	cp.NextMember = cp.CompressedOffset + delta
+	cp.Window = make([]byte, n)
+	_, err = io.ReadFull(br, cp.Window)
+	return err
+}
+
+// checkpoint returns the nearest checkpoint before the given uncompressed offset.
+func (index *CheckpointIndex) checkpoint(off uint64) Checkpoint {
+	i := sort.Search(len(index.Checkpoints), func(i int) bool {
+		return index.Checkpoints[i].Offset > off
+	})
+	if i == 0 {
+		return Checkpoint{}
+	}
+	return index.Checkpoints[i-1]
+}
+
+func buildZstdCheckpoints(f FileReader, index *CheckpointIndex, appendCheckpoint func(uint64, uint64)) error {
+	r := &countingReader{r: bufio.NewReader(io.NewSectionReader(f, 0, 1<<62))}
+	d, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	for {
+		offset := r.n
+		size, skippable, err := zstdFrameSize(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if skippable {
+			continue
+		}
+
+		if err = d.Reset(io.NewSectionReader(f, int64(offset), int64(size))); err != nil {
+			return err
+		}
+		n, err := io.Copy(ioutil.Discard, d)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			appendCheckpoint(index.Size, offset)
+		}
+		index.Size += uint64(n)
+	}
+}
+
+// zstdFrameSize reads the given frame and returns its compressed size.
+// See https://github.com/facebook/zstd/blob/dev/doc/zstd_compression_format.md#frames
+func zstdFrameSize(r *countingReader) (uint64, bool, error) {
+	start := r.n
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, false, err // io.EOF at the end of the file
+	}
+
+	magic := binary.LittleEndian.Uint32(header)
+	if magic&zstdSkippableMagicMask == zstdSkippableMagic {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return 0, false, unexpectedEOF(err)
+		}
+		if err := r.skip(uint64(binary.LittleEndian.Uint32(header))); err != nil {
+			return 0, false, err
+		}
+		return r.n - start, true, nil
+	}
+	if !bytes.Equal(header, zstdMagic) {
+		return 0, false, ErrUnknownCompression
+	}
+
+	// Frame header
+	descriptor, err := r.ReadByte()
+	if err != nil {
+		return 0, false, unexpectedEOF(err)
+	}
+	singleSegment := descriptor&0x20 != 0
+	var n uint64
+	if !singleSegment {
+		n++ // Window descriptor
+	}
+	n += []uint64{0, 1, 2, 4}[descriptor&0x03] // Dictionary ID
+	switch fcs := descriptor >> 6; {
+	case fcs == 0 && singleSegment:
+		n++
+	case fcs > 0:
+		n += 1 << fcs // Frame content size
+	}
+	if err = r.skip(n); err != nil {
+		return 0, false, err
+	}
+
+	// Blocks
+	for last := false; !last; {
+		if _, err := io.ReadFull(r, header[:3]); err != nil {
+			return 0, false, unexpectedEOF(err)
+		}
+		bh := uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16
+		last = bh&1 != 0
+		size := uint64(bh >> 3)
+		switch (bh >> 1) & 0x03 {
+		case 1:
+			size = 1 // RLE block
+		case 3:
+			return 0, false, errors.New("zstd: reserved block type")
+		}
+		if err = r.skip(size); err != nil {
+			return 0, false, err
+		}
+	}
+
+	if descriptor&0x04 != 0 {
+		if err = r.skip(4); err != nil { // Content checksum
+			return 0, false, err
+		}
+	}
+
+	return r.n - start, false, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// countingReader counts the read bytes.
+type countingReader struct {
+	r *bufio.Reader
+	n uint64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.n += uint64(n)
+	return n, err
+}
+
+func (r *countingReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return b, err
+}
+
+func (r *countingReader) skip(n uint64) error {
+	d, err := r.r.Discard(int(n))
+	r.n += uint64(d)
+	return unexpectedEOF(err)
+}
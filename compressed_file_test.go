@@ -0,0 +1,289 @@
+package iosupport_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	. "github.com/mdouchement/iosupport"
+	"github.com/mdouchement/stringio"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func gzipMembers(members ...string) *stringio.StringIO {
+	var buf bytes.Buffer
+	for _, member := range members {
+		w := gzip.NewWriter(&buf)
+		w.Write([]byte(member))
+		w.Close()
+	}
+	return stringio.NewFromString(buf.String())
+}
+
+func gzipLevel(level int, content string) *stringio.StringIO {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	check(err)
+	w.Write([]byte(content))
+	w.Close()
+	return stringio.NewFromString(buf.String())
+}
+
+// countingFile counts the bytes read with ReadAt.
+type countingFile struct {
+	*stringio.StringIO
+	n int
+}
+
+func (f *countingFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.StringIO.ReadAt(b, off)
+	f.n += n
+	return n, err
+}
+
+func zstdFrames(frames ...string) *stringio.StringIO {
+	var buf bytes.Buffer
+	for _, frame := range frames {
+		w, err := zstd.NewWriter(&buf)
+		check(err)
+		w.Write([]byte(frame))
+		w.Close()
+	}
+	return stringio.NewFromString(buf.String())
+}
+
+var _ = Describe("CompressedFile", func() {
+	var members = []string{"c1,c2\nb,2\n", "a,1\nd,4\n", strings.Repeat("e,5\n", 1000)}
+	var content = strings.Join(members, "")
+
+	for _, format := range []string{"gzip", "zstd"} {
+		format := format
+		var file = func() *stringio.StringIO {
+			if format == "gzip" {
+				return gzipMembers(members...)
+			}
+			return zstdFrames(members...)
+		}
+
+		Describe(fmt.Sprintf("with %s", format), func() {
+			Describe(".BuildCheckpointIndex", func() {
+				It("records a checkpoint per member", func() {
+					index, err := BuildCheckpointIndex(file(), 0)
+					check(err)
+
+					Expect(index.Size).To(Equal(uint64(len(content))))
+					Expect(index.Checkpoints).To(HaveLen(3))
+					Expect(index.Checkpoints[1].Offset).To(Equal(uint64(10)))
+					Expect(index.Checkpoints[2].Offset).To(Equal(uint64(18)))
+				})
+
+				It("keeps checkpoints according to the interval", func() {
+					index, err := BuildCheckpointIndex(file(), 15)
+					check(err)
+
+					Expect(index.Checkpoints).To(HaveLen(2))
+					Expect(index.Checkpoints[1].Offset).To(Equal(uint64(18)))
+				})
+			})
+
+			Describe("#ReadAt", func() {
+				It("reads at uncompressed offsets", func() {
+					subject, err := NewCompressedFile(file())
+					check(err)
+
+					for _, off := range []int64{4000, 12, 0, 20, 10, 4010} {
+						b := make([]byte, 6)
+						n, err := subject.ReadAt(b, off)
+						Expect(string(b[:n])).To(Equal(content[off : off+int64(n)]))
+						if n < len(b) {
+							Expect(err).To(Equal(io.EOF))
+						} else {
+							check(err)
+						}
+					}
+				})
+			})
+
+			Describe("#Seek", func() {
+				It("moves at uncompressed offsets", func() {
+					subject, err := NewCompressedFile(file())
+					check(err)
+
+					pos, err := subject.Seek(-4, io.SeekEnd)
+					check(err)
+					Expect(pos).To(Equal(int64(len(content) - 4)))
+
+					b := make([]byte, 10)
+					n, _ := subject.Read(b)
+					Expect(string(b[:n])).To(Equal("e,5\n"))
+				})
+			})
+
+			Describe("with a Scanner", func() {
+				It("reads the uncompressed lines", func() {
+					subject, err := NewCompressedFile(file())
+					check(err)
+
+					var buf bytes.Buffer
+					sc := NewScanner(subject)
+					sc.KeepNewlineSequence(true)
+					for sc.ScanLine() {
+						check(sc.Err())
+						buf.Write(sc.Bytes())
+					}
+					Expect(buf.String()).To(Equal(content))
+				})
+			})
+
+			Describe("with a TsvIndexer", func() {
+				It("sorts the uncompressed TSV", func() {
+					sc := func() *Scanner {
+						cf, err := NewCompressedFile(file())
+						check(err)
+						return NewScanner(cf)
+					}
+					subject := NewTsvIndexer(sc, HasHeader(), Separator(","), Fields("c1"))
+					output := stringio.New()
+
+					check(subject.Analyze())
+					subject.Sort()
+					check(subject.Transfer(output))
+
+					Expect(output.GetValueString()).To(Equal("c1,c2\na,1\nb,2\nd,4\n" + strings.Repeat("e,5\n", 1000)))
+				})
+			})
+		})
+	}
+
+	Describe("with a single gzip member", func() {
+		var random = rand.New(rand.NewSource(42))
+		var lines = make([]string, 100000)
+		for i := range lines {
+			lines[i] = fmt.Sprintf("%d,%x,%s\n", i, random.Int63(), strings.Repeat("z", random.Intn(20)))
+		}
+		var content = strings.Join(lines, "")
+		var interval uint64 = 64 << 10
+
+		for _, level := range []int{gzip.NoCompression, gzip.HuffmanOnly, gzip.BestSpeed, gzip.DefaultCompression, gzip.BestCompression} {
+			level := level
+			var file = gzipLevel(level, content)
+
+			Describe(fmt.Sprintf("compressed with the level %d", level), func() {
+				var index *CheckpointIndex
+
+				BeforeEach(func() {
+					var err error
+					index, err = BuildCheckpointIndex(file, interval)
+					check(err)
+				})
+
+				It("records checkpoints with the window inside the member", func() {
+					Expect(index.Size).To(Equal(uint64(len(content))))
+					// Checkpoints are only on deflate block boundaries.
+					Expect(len(index.Checkpoints)).To(BeNumerically(">", 8))
+					for _, cp := range index.Checkpoints[1:] {
+						Expect(cp.Window).To(Equal([]byte(content[cp.Offset-32<<10 : cp.Offset])))
+						Expect(cp.NextMember).To(BeEquivalentTo(len(file.GetValueString())))
+					}
+				})
+
+				It("reads at uncompressed offsets", func() {
+					subject := NewCompressedFileWithIndex(file, index)
+
+					for i := 0; i < 50; i++ {
+						off := random.Int63n(int64(len(content)))
+						b := make([]byte, 1+random.Intn(100000))
+						n, err := subject.ReadAt(b, off)
+						Expect(string(b[:n])).To(Equal(content[off : off+int64(n)]))
+						if n < len(b) {
+							Expect(err).To(Equal(io.EOF))
+						} else {
+							check(err)
+						}
+					}
+				})
+
+				It("decompresses at most an interval on a backward read", func() {
+					cf := &countingFile{StringIO: file}
+					subject := NewCompressedFileWithIndex(cf, index)
+
+					b := make([]byte, 10)
+					for _, off := range []int64{int64(len(content)) - 10, int64(len(content)) / 2} {
+						cf.n = 0
+						_, err := subject.ReadAt(b, off)
+						check(err)
+						Expect(string(b)).To(Equal(content[off : off+10]))
+						Expect(cf.n).To(BeNumerically("<", len(file.GetValueString())/4))
+					}
+				})
+
+				It("writes and reads back the windows of the index", func() {
+					var buf bytes.Buffer
+					_, err := index.WriteTo(&buf)
+					check(err)
+
+					actual, err := ReadCheckpointIndex(&buf)
+					check(err)
+					Expect(actual).To(Equal(index))
+				})
+			})
+		}
+
+		It("resumes the members that follow a checkpoint", func() {
+			members := gzipMembers(content[:200000], content[200000:200010], "", content[200010:])
+			index, err := BuildCheckpointIndex(members, interval)
+			check(err)
+			subject := NewCompressedFileWithIndex(members, index)
+
+			b := make([]byte, 100000)
+			n, err := subject.ReadAt(b, 150000)
+			check(err)
+			Expect(string(b[:n])).To(Equal(content[150000:250000]))
+		})
+
+		It("aligns the resumed deflate blocks on every bit", func() {
+			bits := map[uint8]bool{}
+			for _, level := range []int{gzip.HuffmanOnly, gzip.BestSpeed, gzip.DefaultCompression} {
+				index, err := BuildCheckpointIndex(gzipLevel(level, content), 4<<10)
+				check(err)
+				for _, cp := range index.Checkpoints {
+					bits[cp.Bits] = true
+				}
+			}
+			Expect(bits).To(HaveLen(8))
+		})
+	})
+
+	Describe("CheckpointIndex", func() {
+		It("is written and read back", func() {
+			index, err := BuildCheckpointIndex(gzipMembers(members...), 0)
+			check(err)
+
+			var buf bytes.Buffer
+			_, err = index.WriteTo(&buf)
+			check(err)
+
+			actual, err := ReadCheckpointIndex(&buf)
+			check(err)
+			Expect(actual).To(Equal(index))
+		})
+
+		It("detects an invalid index", func() {
+			_, err := ReadCheckpointIndex(strings.NewReader("trololo"))
+			Expect(err).To(Equal(ErrInvalidIndex))
+		})
+	})
+
+	Describe(".DetectCompression", func() {
+		It("detects an unknown format", func() {
+			_, err := DetectCompression(stringio.NewFromString(content))
+			Expect(err).To(Equal(ErrUnknownCompression))
+		})
+	})
+})
@@ -0,0 +1,258 @@
+package iosupport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"unicode/utf8"
+)
+
+// This CsvReader is a drop-in replacement of encoding/csv.Reader backed by a Scanner and a TsvParser:
+//
+// r := iosupport.NewCsvReader(file)
+// r.Comma = ';'
+// for {
+//   record, err := r.Read()
+//   if err == io.EOF {
+//     break
+//   }
+//   if err != nil {
+//     panic(err)
+//   }
+//   println(record[0])
+// }
+//
+// The records, the errors (*csv.ParseError with csv.ErrQuote, csv.ErrBareQuote or csv.ErrFieldCount), FieldPos
+// and InputOffset are the ones of encoding/csv, except that:
+// - the input must be a FileReader instead of an io.Reader;
+// - a byte order mark at the beginning of the file is skipped (it is part of the first field with encoding/csv);
+// - after a parse error, Read resumes at the next record as delimited by the quotes (encoding/csv resumes at the next line).
+
+// ErrInvalidDelim -> invalid Comma or Comment of a CsvReader (same message as encoding/csv)
+var ErrInvalidDelim = errors.New("csv: invalid field or comment delimiter")
+
+// A CsvReader reads records from a CSV-encoded file like encoding/csv.Reader.
+// The exported fields can be changed to customize the details before the first call to Read or ReadAll.
+type CsvReader struct {
+	Comma            rune // field delimiter (set to `,' by NewCsvReader)
+	Comment          rune // comment character (0 means no comment)
+	FieldsPerRecord  int  // number of expected fields per record (0 means the number of fields of the first record)
+	LazyQuotes       bool // allow lazy quotes
+	TrimLeadingSpace bool // leading white space in a field is ignored
+	ReuseRecord      bool // the record slice is reused by the next call to Read
+
+	parser  *TsvParser
+	comma   rune     // Comma applied to the parser.
+	comment rune     // Comment applied to the parser.
+	record  []string // Reused record (see ReuseRecord).
+}
+
+// NewCsvReader instanciates a new CsvReader.
+// Only LF ends a line, the CR of a CRLF sequence is dropped (a CRLF inside a quoted field is returned as `\n').
+func NewCsvReader(f FileReader) *CsvReader {
+	sc := NewScanner(f)
+	sc.SetDelimiter(LFDelimiter)
+	parser := NewTsvParser(sc, ',')
+	parser.ReuseRow = true
+	parser.trimCR = true
+	return &CsvReader{
+		Comma:  ',',
+		parser: parser,
+		comma:  ',',
+	}
+}
+
+// Read reads one record (a slice of fields) from r.
+// If the record has an unexpected number of fields, Read returns the record along with a csv.ErrFieldCount error.
+// If the record contains a field that cannot be parsed, Read returns the fields read before the error along with the error.
+// If there is no data left to be read, Read returns nil, io.EOF.
+func (r *CsvReader) Read() ([]string, error) {
+	var record []string
+	if r.ReuseRecord {
+		record = r.record
+	}
+	record, err := r.readRecord(record)
+	if r.ReuseRecord {
+		r.record = record
+	}
+	return record, err
+}
+
+// ReadAll reads all the remaining records from r.
+// A successful call returns a nil error, not io.EOF. The records are returned only when all of them are read.
+func (r *CsvReader) ReadAll() ([][]string, error) {
+	records := [][]string{}
+	for {
+		record, err := r.readRecord(nil)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// FieldPos returns the line and the column (1-based byte index) of the start of the field with the given index
+// in the record most recently returned by Read. It panics if field is out of range.
+func (r *CsvReader) FieldPos(field int) (line, column int) {
+	positions := r.parser.FieldPositions()
+	if field < 0 || field >= len(positions) {
+		panic("out of range index passed to FieldPos")
+	}
+	return r.position(positions[field].Start)
+}
+
+// InputOffset returns the input stream byte offset of the current reader position.
+// The offset gives the location of the end of the most recently read record and the beginning of the next one.
+func (r *CsvReader) InputOffset() int64 {
+	return int64(r.parser.Scanner.Offset() + uint64(r.parser.Scanner.Limit()))
+}
+
+// readRecord reads the next record that is not empty.
+func (r *CsvReader) readRecord(record []string) ([]string, error) {
+	if err := r.configure(); err != nil {
+		return nil, err
+	}
+
+	tp := r.parser
+	for {
+		tp.err = nil // A parse error only affects its record
+		if !tp.ScanRow() {
+			if tp.err != nil {
+				return nil, tp.err
+			}
+			return nil, io.EOF
+		}
+		if len(tp.lineBytes()) > 0 {
+			break // Empty lines are skipped
+		}
+	}
+
+	// One string for the whole record, the fields are sliced from it
+	row := tp.Row()
+	n := 0
+	for _, field := range row {
+		n += len(field)
+	}
+	buf := make([]byte, 0, n+1)
+	for _, field := range row {
+		buf = append(buf, field...)
+	}
+	if len(row) > 0 && r.isUnterminated() {
+		buf = append(buf, LF) // The lazy quoted field keeps the last newline of the input
+	}
+	s := string(buf)
+
+	record = record[:0]
+	n = 0
+	for i, field := range row {
+		if i == len(row)-1 {
+			record = append(record, s[n:])
+			break
+		}
+		record = append(record, s[n:n+len(field)])
+		n += len(field)
+	}
+
+	// A partial record is returned along with a parse error
+	err := tp.err
+	var perr *ParseError
+	if errors.As(err, &perr) {
+		err = r.parseError(perr)
+	}
+
+	if r.FieldsPerRecord > 0 {
+		if len(record) != r.FieldsPerRecord && err == nil {
+			err = &csv.ParseError{StartLine: tp.Line(), Line: tp.Line(), Column: 1, Err: csv.ErrFieldCount}
+		}
+	} else if r.FieldsPerRecord == 0 {
+		r.FieldsPerRecord = len(record)
+	}
+	return record, err
+}
+
+// configure validates the delimiters and applies them to the parser.
+func (r *CsvReader) configure() error {
+	if r.Comma == r.Comment || !validDelim(r.Comma) || (r.Comment != 0 && !validDelim(r.Comment)) {
+		return ErrInvalidDelim
+	}
+
+	tp := r.parser
+	if r.Comma != r.comma {
+		tp.SetSeparator([]byte(string(r.Comma)))
+		r.comma = r.Comma
+	}
+	if r.Comment != r.comment {
+		tp.comment = nil
+		if r.Comment != 0 {
+			tp.comment = []byte(string(r.Comment))
+		}
+		r.comment = r.Comment
+	}
+	tp.LazyQuotes = r.LazyQuotes
+	tp.TrimLeadingSpace = r.TrimLeadingSpace
+	return nil
+}
+
+// parseError converts a ParseError of the parser into a csv.ParseError.
+func (r *CsvReader) parseError(err *ParseError) error {
+	cause := csv.ErrBareQuote
+	if err.Err == ErrQuote {
+		cause = csv.ErrQuote
+	}
+
+	record := r.parser.lineBytes()
+	if err.Err == ErrQuote && err.Column >= len(record) {
+		// Unterminated quoted field, the error is after the last byte of the record (including its newline)
+		newline := r.endsWithNewline()
+		if !newline && bytes.HasSuffix(record, []byte{LF}) {
+			// The last line is a lone CR at the end of the input, encoding/csv drops it like an empty line
+			record = record[:len(record)-1]
+			newline = true
+		}
+		line, column := r.position(len(record))
+		if newline {
+			column++
+		}
+		return &csv.ParseError{StartLine: r.parser.Line(), Line: line, Column: column, Err: cause}
+	}
+
+	// The column of the parser error is the index following the invalid quote
+	line, column := r.position(err.Column - 1)
+	return &csv.ParseError{StartLine: r.parser.Line(), Line: line, Column: column, Err: cause}
+}
+
+// position returns the line and the column (1-based byte index) of the given index of the current record.
+func (r *CsvReader) position(i int) (line, column int) {
+	record := r.parser.lineBytes()
+	if i > len(record) {
+		i = len(record)
+	}
+	line = r.parser.Line() + bytes.Count(record[:i], []byte{LF})
+	return line, i - bytes.LastIndexByte(record[:i], LF)
+}
+
+// isUnterminated says if the current record ends with a lazy quoted field left open at the end of the input
+// and followed by a newline.
+func (r *CsvReader) isUnterminated() bool {
+	tp := r.parser
+	return r.LazyQuotes && tp.isQuoteOpen(tp.lineBytes(), false) && r.endsWithNewline()
+}
+
+// endsWithNewline says if the current record is followed by a newline in the file.
+func (r *CsvReader) endsWithNewline() bool {
+	tp := r.parser
+	if tp.Limit() == 0 {
+		return false
+	}
+	b, err := tp.ReadAt(int64(tp.Offset()+uint64(tp.Limit()))-1, 1)
+	return err == nil && b[0] == LF
+}
+
+// validDelim says if the rune can be a field or a comment delimiter.
+func validDelim(r rune) bool {
+	return r != 0 && r != '"' && r != '\r' && r != '\n' && utf8.ValidRune(r) && r != utf8.RuneError
+}
@@ -0,0 +1,217 @@
+package iosupport_test
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+
+	. "github.com/mdouchement/iosupport"
+	"github.com/mdouchement/stringio"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// csvRead is the result of a call to Read with the state of the reader.
+type csvRead struct {
+	Record    []string
+	Err       string
+	Positions [][2]int
+	Offset    int64
+}
+
+type csvOptions struct {
+	Comma            rune
+	Comment          rune
+	FieldsPerRecord  int
+	LazyQuotes       bool
+	TrimLeadingSpace bool
+	ReuseRecord      bool
+}
+
+// readCsv reads all the records with Read, it stops at the first error that is not a parse error.
+func readCsv(read func() ([]string, error), fieldPos func(int) (int, int), offset func() int64) []csvRead {
+	reads := []csvRead{}
+	for i := 0; i < 100; i++ {
+		record, err := read()
+		r := csvRead{Record: append([]string{}, record...), Offset: offset()}
+		if err != nil {
+			r.Err = err.Error()
+		}
+		if err == nil || errors.Is(err, csv.ErrFieldCount) {
+			for j := range record {
+				line, column := fieldPos(j)
+				r.Positions = append(r.Positions, [2]int{line, column})
+			}
+		}
+		reads = append(reads, r)
+
+		var perr *csv.ParseError
+		if err != nil && !errors.As(err, &perr) {
+			break
+		}
+	}
+	return reads
+}
+
+// untilParseError drops the reads that follow the first parse error and its offset,
+// the readers do not resume at the same place.
+func untilParseError(reads []csvRead) []csvRead {
+	for i, r := range reads {
+		if r.Err != "" && !strings.HasSuffix(r.Err, csv.ErrFieldCount.Error()) {
+			reads[i].Offset = 0
+			return reads[:i+1]
+		}
+	}
+	return reads
+}
+
+// newCsvReaders returns an encoding/csv reader and a CsvReader of the given input configured with the given options.
+func newCsvReaders(input string, opts csvOptions) (*csv.Reader, *CsvReader) {
+	expected := csv.NewReader(strings.NewReader(input))
+	subject := NewCsvReader(stringio.NewFromString(input))
+	if opts.Comma != 0 {
+		expected.Comma = opts.Comma
+		subject.Comma = opts.Comma
+	}
+	expected.Comment, subject.Comment = opts.Comment, opts.Comment
+	expected.FieldsPerRecord, subject.FieldsPerRecord = opts.FieldsPerRecord, opts.FieldsPerRecord
+	expected.LazyQuotes, subject.LazyQuotes = opts.LazyQuotes, opts.LazyQuotes
+	expected.TrimLeadingSpace, subject.TrimLeadingSpace = opts.TrimLeadingSpace, opts.TrimLeadingSpace
+	expected.ReuseRecord, subject.ReuseRecord = opts.ReuseRecord, opts.ReuseRecord
+	return expected, subject
+}
+
+var _ = Describe("CsvReader", func() {
+	var inputs = []string{
+		"a,b,c\n1,2,3\n",
+		"a,b,c\r\n1,2,3\r\n",
+		"a,b,c\n1,2,3",
+		"a,b,c\r",
+		"\n\na,b\n\n\nc,d\n\n",
+		"a,,c\n,\n,,\n",
+		`"a","b c",d` + "\n" + `"1,2","""x""",""` + "\n",
+		"\"multi\nline\",x\n\"crlf\r\nline\",y\r\n",
+		"\"a\n\nb\",c\nd,e\n",
+		" a,  b ,\t c\n  \" q\",r\n",
+		" a,  \n",
+		"# comment\na,b\n#c,d\ne,f\n # not a comment\n",
+		"a;b;c\n1;2;3\n",
+		"a\tb\n\"1\t2\"\t3\n",
+		"a,b\n1,2,3\n4\n5,6\n",
+		`a,b"c,d` + "\n" + "e,f\n",
+		`"a"b,c` + "\n" + "d,e\n",
+		`"a" ,b` + "\n" + "c,d\n",
+		`a,"b` + "\n",
+		`a,"b`,
+		`"a` + "\n\n",
+		`"a` + "\r\n",
+		`"b,`,
+		`"ba` + "\n,",
+		`"` + "\r\r",
+		`"` + "\n\r",
+		`"a` + "\n,b,\n\r",
+		"x,y\n\"a\nb",
+		`a,"b""c"d,e` + "\n",
+		`"a""",b` + "\n",
+		"é,ü,\"ß\"\n€,\"x\"\"y\",z\n",
+		"a§b§\"c§d\"\n",
+		"",
+		"\n\n",
+	}
+
+	var options = []csvOptions{
+		{},
+		{FieldsPerRecord: -1},
+		{FieldsPerRecord: 2},
+		{LazyQuotes: true, FieldsPerRecord: -1},
+		{TrimLeadingSpace: true, FieldsPerRecord: -1},
+		{TrimLeadingSpace: true, LazyQuotes: true, FieldsPerRecord: -1},
+		{Comment: '#', FieldsPerRecord: -1},
+		{Comma: ';'},
+		{Comma: '\t', FieldsPerRecord: -1},
+		{Comma: '§', FieldsPerRecord: -1},
+		{ReuseRecord: true, FieldsPerRecord: -1},
+	}
+
+	Describe("#Read", func() {
+		for _, input := range inputs {
+			for _, opts := range options {
+				input, opts := input, opts
+
+				It(fmt.Sprintf("reads %q like encoding/csv with %+v", input, opts), func() {
+					expected, subject := newCsvReaders(input, opts)
+					Expect(readCsv(subject.Read, subject.FieldPos, subject.InputOffset)).To(Equal(
+						readCsv(expected.Read, expected.FieldPos, expected.InputOffset)))
+				})
+			}
+		}
+
+		It("reads random inputs like encoding/csv until the first parse error", func() {
+			var random = rand.New(rand.NewSource(42))
+			var alphabet = []string{"a", "b", " ", ",", ";", "#", "\"", "\"", "\n", "\r", "\t", "é", "§"}
+
+			for i := 0; i < 5000; i++ {
+				var input strings.Builder
+				for n := random.Intn(24); n > 0; n-- {
+					input.WriteString(alphabet[random.Intn(len(alphabet))])
+				}
+				opts := options[random.Intn(len(options))]
+				expected, subject := newCsvReaders(input.String(), opts)
+
+				Expect(untilParseError(readCsv(subject.Read, subject.FieldPos, subject.InputOffset))).To(Equal(
+					untilParseError(readCsv(expected.Read, expected.FieldPos, expected.InputOffset))),
+					fmt.Sprintf("%q with %+v", input.String(), opts))
+			}
+		})
+
+		It("returns the errors of encoding/csv", func() {
+			subject := NewCsvReader(stringio.NewFromString("a,b\n1,2,3\n\"x\"y\n"))
+
+			_, err := subject.Read()
+			check(err)
+			record, err := subject.Read()
+			Expect(record).To(Equal([]string{"1", "2", "3"}))
+			Expect(errors.Is(err, csv.ErrFieldCount)).To(BeTrue())
+			_, err = subject.Read()
+			Expect(errors.Is(err, csv.ErrQuote)).To(BeTrue())
+			_, err = subject.Read()
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		It("rejects an invalid delimiter", func() {
+			subject := NewCsvReader(stringio.NewFromString("a,b\n"))
+			subject.Comment = ','
+
+			_, err := subject.Read()
+			Expect(err).To(Equal(ErrInvalidDelim))
+		})
+
+		It("skips the byte order mark", func() {
+			subject := NewCsvReader(stringio.NewFromString("\xEF\xBB\xBFa,b\n"))
+
+			record, err := subject.Read()
+			check(err)
+			Expect(record).To(Equal([]string{"a", "b"}))
+			Expect(subject.InputOffset()).To(BeEquivalentTo(7))
+		})
+	})
+
+	Describe("#ReadAll", func() {
+		It("reads all the records like encoding/csv", func() {
+			for _, input := range inputs {
+				expected, expectedErr := csv.NewReader(strings.NewReader(input)).ReadAll()
+				actual, err := NewCsvReader(stringio.NewFromString(input)).ReadAll()
+
+				Expect(fmt.Sprint(err)).To(Equal(fmt.Sprint(expectedErr)), input)
+				if expected == nil && expectedErr == nil {
+					expected = [][]string{}
+				}
+				Expect(actual).To(Equal(expected), input)
+			}
+		})
+	})
+})
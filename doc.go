@@ -7,7 +7,7 @@
 // and it can parse and sort CSV files.
 //
 // There are many kinds of CSV files; this package supports the format
-// described in RFC 4180 except newlines in quoted fields.
+// described in RFC 4180.
 //
 // A CSV file contains zero or more records of one or more fields per record.
 // Each record is separated by the newline character. The final record may
@@ -18,6 +18,8 @@
 //
 // Carriage returns before newline characters are silently removed.
 //
+// Lines beginning with the comment character (disabled by default) are ignored.
+//
 // Fields which start and stop with the quote character " are called
 // quoted-fields. The beginning and ending quote are not part of the
 // field.
@@ -38,4 +40,14 @@
 // results in
 //
 //	{`the "word" is true`, `a "quoted-field"`}
+//
+// Newlines and commas may be included in a quoted-field
+//
+//	"Multi-line
+//	field","comma is ,"
+//
+// results in
+//
+//	{`Multi-line
+//	field`, `comma is ,`}
 package iosupport
@@ -0,0 +1,162 @@
+package iosupport
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+var (
+	// UTF8BOM is the byte order mark of UTF-8 files.
+	UTF8BOM = []byte{0xEF, 0xBB, 0xBF}
+	// UTF16LEBOM is the byte order mark of UTF-16 little-endian files.
+	UTF16LEBOM = []byte{0xFF, 0xFE}
+	// UTF16BEBOM is the byte order mark of UTF-16 big-endian files.
+	UTF16BEBOM = []byte{0xFE, 0xFF}
+
+	// UTF16LE is the UTF-16 little-endian encoding (the BOM is skipped by the Scanner).
+	UTF16LE = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	// UTF16BE is the UTF-16 big-endian encoding (the BOM is skipped by the Scanner).
+	UTF16BE = unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	// Latin1 is the ISO 8859-1 encoding.
+	Latin1 encoding.Encoding = charmap.ISO8859_1
+)
+
+// encodingSampleSize is the number of bytes used to detect the encoding of a file.
+const encodingSampleSize = 4096
+
+// DetectEncoding guesses the encoding of the given file from its first bytes.
+// It returns nil for UTF-8 (or ASCII) files, UTF16LE/UTF16BE when there is a BOM or
+// when the file looks like UTF-16 and Latin1 when the file is not valid UTF-8.
+func DetectEncoding(f FileReader) (encoding.Encoding, error) {
+	sample := make([]byte, encodingSampleSize)
+	n, err := f.ReadAt(sample, 0)
+	if n == 0 && err != nil {
+		return nil, nil // Empty file
+	}
+	sample = sample[:n]
+
+	switch {
+	case bytes.HasPrefix(sample, UTF8BOM):
+		return nil, nil
+	case bytes.HasPrefix(sample, UTF16LEBOM):
+		return UTF16LE, nil
+	case bytes.HasPrefix(sample, UTF16BEBOM):
+		return UTF16BE, nil
+	}
+
+	// ASCII characters encoded in UTF-16 have a null byte
+	var even, odd int
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			even++
+		} else {
+			odd++
+		}
+	}
+	if half := len(sample) / 2; half > 0 {
+		switch {
+		case odd > half/2 && even == 0:
+			return UTF16LE, nil
+		case even > half/2 && odd == 0:
+			return UTF16BE, nil
+		}
+	}
+
+	// The last rune of the sample may be truncated
+	if n == encodingSampleSize {
+		for i := 0; i < utf8.UTFMax && len(sample) > 0 && !utf8.RuneStart(sample[len(sample)-1]); i++ {
+			sample = sample[:len(sample)-1]
+		}
+		if len(sample) > 0 {
+			sample = sample[:len(sample)-1]
+		}
+	}
+	if !utf8.Valid(sample) {
+		return Latin1, nil
+	}
+	return nil, nil
+}
+
+// detectBOM looks for a byte order mark at the beginning of the file.
+// The encoding is defined according the found BOM when it is not already set.
+func (s *Scanner) detectBOM() {
+	s.detected = true
+
+	s.bom = readBOM(s.f)
+	switch {
+	case bytes.Equal(s.bom, UTF16LEBOM):
+		s.setDetectedEncoding(UTF16LE)
+	case bytes.Equal(s.bom, UTF16BEBOM):
+		s.setDetectedEncoding(UTF16BE)
+	}
+}
+
+// readBOM returns the byte order mark found at the beginning of the file (nil when there is none).
+func readBOM(f FileReader) []byte {
+	head := make([]byte, len(UTF8BOM))
+	n, _ := f.ReadAt(head, 0)
+	head = head[:n]
+
+	for _, bom := range [][]byte{UTF8BOM, UTF16LEBOM, UTF16BEBOM} {
+		if bytes.HasPrefix(head, bom) {
+			return bom
+		}
+	}
+	return nil
+}
+
+// setDetectedEncoding uses the given encoding when no encoding is defined.
+func (s *Scanner) setDetectedEncoding(enc encoding.Encoding) {
+	if s.enc == nil {
+		s.useEncoding(enc)
+	}
+}
+
+func (s *Scanner) useEncoding(enc encoding.Encoding) {
+	s.enc = enc
+	s.nlLen = 1
+	if enc != nil {
+		s.encoder = encoding.ReplaceUnsupported(enc.NewEncoder())
+		s.nlLen = len(s.encode([]byte{LF}))
+	}
+}
+
+// encode encodes the given UTF-8 bytes in the encoding of the file.
+func (s *Scanner) encode(b []byte) []byte {
+	if s.enc == nil {
+		return b
+	}
+	s.encoder.Reset()
+	v, _, _ := transform.Bytes(s.encoder, b)
+	return v
+}
+
+// sourceLen returns the length of the given UTF-8 bytes in the encoding of the file.
+func (s *Scanner) sourceLen(b []byte) int {
+	if s.enc == nil {
+		return len(b)
+	}
+
+	if s.encbuf == nil {
+		s.encbuf = make([]byte, 4096)
+	}
+	s.encoder.Reset()
+
+	n := 0
+	for {
+		nDst, nSrc, err := s.encoder.Transform(s.encbuf, b, true)
+		n += nDst
+		b = b[nSrc:]
+		if err != transform.ErrShortDst {
+			return n
+		}
+	}
+}
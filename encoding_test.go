@@ -0,0 +1,113 @@
+package iosupport_test
+
+import (
+	. "github.com/mdouchement/iosupport"
+	"github.com/mdouchement/stringio"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encoding", func() {
+	Describe("DetectEncoding", func() {
+		It("detects UTF-8 files", func() {
+			Expect(DetectEncoding(stringio.NewFromString("héhé\n"))).To(BeNil())
+			Expect(DetectEncoding(stringio.NewFromString("\xEF\xBB\xBFhéhé\n"))).To(BeNil())
+		})
+
+		It("detects UTF-16 files with a BOM", func() {
+			Expect(DetectEncoding(stringio.NewFromString("\xFF\xFEa\x00"))).To(Equal(UTF16LE))
+			Expect(DetectEncoding(stringio.NewFromString("\xFE\xFF\x00a"))).To(Equal(UTF16BE))
+		})
+
+		It("detects UTF-16 files without BOM", func() {
+			Expect(DetectEncoding(stringio.NewFromString(utf16le("abc\ndef\n")))).To(Equal(UTF16LE))
+		})
+
+		It("detects Latin-1 files", func() {
+			Expect(DetectEncoding(stringio.NewFromString("h\xE9h\xE9\n"))).To(Equal(Latin1))
+		})
+	})
+
+	Describe("Scanner", func() {
+		Context("with a UTF-8 BOM", func() {
+			var subject = NewScanner(stringio.NewFromString("\xEF\xBB\xBFline1\nline2\n"))
+			var actual = []string{}
+			var offsets = []uint64{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+				offsets = append(offsets, subject.Offset())
+			}
+
+			It("skips the BOM", func() {
+				Expect(actual).To(Equal([]string{"line1", "line2"}))
+				Expect(subject.BOM()).To(Equal(UTF8BOM))
+			})
+
+			It("computes the offsets in the file", func() {
+				Expect(offsets).To(Uint64ConsistOf(3, 9))
+			})
+		})
+
+		Context("with a UTF-16LE BOM", func() {
+			var subject = NewScanner(stringio.NewFromString("\xFF\xFE" + utf16le("héhé\r\nline2\n")))
+			var actual = []string{}
+			var offsets = []uint64{}
+			var limits = []uint32{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+				offsets = append(offsets, subject.Offset())
+				limits = append(limits, subject.Limit())
+			}
+
+			It("transcodes the lines to UTF-8", func() {
+				Expect(actual).To(Equal([]string{"héhé", "line2"}))
+			})
+
+			It("computes the offsets and the limits in the file", func() {
+				Expect(offsets).To(Uint64ConsistOf(2, 14))
+				Expect(limits).To(Uint32ConsistOf(12, 12))
+			})
+
+			It("reads the lines in the file", func() {
+				token, err := subject.ReadAt(int64(offsets[1]), int(limits[1]))
+				check(err)
+				Expect(string(token)).To(Equal(utf16le("line2\n")))
+			})
+		})
+
+		Context("with a Latin-1 file", func() {
+			var subject = NewScanner(stringio.NewFromString("h\xE9h\xE9\nline2\n"))
+			subject.SetEncoding(Latin1)
+			var actual = []string{}
+			var offsets = []uint64{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+				offsets = append(offsets, subject.Offset())
+			}
+
+			It("transcodes the lines to UTF-8", func() {
+				Expect(actual).To(Equal([]string{"héhé", "line2"}))
+			})
+
+			It("computes the offsets in the file", func() {
+				Expect(offsets).To(Uint64ConsistOf(0, 5))
+			})
+		})
+	})
+})
+
+// utf16le encodes the given ASCII/Latin-1 string in UTF-16 little-endian.
+func utf16le(s string) string {
+	b := []byte{}
+	for _, r := range s {
+		b = append(b, byte(r), byte(r>>8))
+	}
+	return string(b)
+}
@@ -24,6 +24,8 @@ func main() {
    Example:
      tsvsorter -i iris.csv -s=',' -H -f "Sepal Length" -o S_iris.csv
 
+   The separator and the header are sniffed from the dataset when they are omitted.
+
    IO usage:
      - Local FileSystem: /tmp/iris.csv
      - Hadoop FileSystem: hdfs://tmp/iris.csv
@@ -44,15 +46,15 @@ var flags = []cli.Flag{
 	},
 	cli.StringFlag{
 		Name:  "f, fields",
-		Usage: "Ordered list of columns name to be sorted (pattern: 'col5,col4')",
+		Usage: "Ordered list of columns name to be sorted, optionally typed (pattern: 'col5,col4:int:desc,col3:time=2006-01-02')",
 	},
 	cli.StringFlag{
 		Name:  "s, separator",
-		Usage: "Dataset delimiter",
+		Usage: "Dataset delimiter (sniffed when omitted)",
 	},
 	cli.BoolFlag{
 		Name:  "H, header",
-		Usage: "Dataset has an header",
+		Usage: "Dataset has an header (sniffed when omitted)",
 	},
 	cli.StringFlag{
 		Name:  "u, hadoop-user",
@@ -71,12 +73,11 @@ var flags = []cli.Flag{
 func action(context *cli.Context) error {
 	memory := context.String("m")
 	inputPath := context.String("i")
-	header := context.Bool("H")
 	separator := context.String("s")
 	fields := strings.Split(context.String("f"), ",")
 	outputPath := context.String("o")
 
-	if inputPath == "" || separator == "" || context.String("f") == "" || outputPath == "" {
+	if inputPath == "" || context.String("f") == "" || outputPath == "" {
 		defer cli.ShowAppHelp(context)
 		panic(fmt.Errorf("Invalid command line"))
 	}
@@ -106,15 +107,23 @@ func action(context *cli.Context) error {
 		}
 		return iosupport.NewScanner(file)
 	}
-	indexer := iosupport.NewTsvIndexer(sc,
-		iosupport.Header(header),
-		iosupport.Separator(separator),
+	options := []iosupport.Option{
+		iosupport.SniffDialect(iosupport.DefaultSniffLines),
 		iosupport.Fields(fields...),
 		iosupport.LazyQuotesMode(),
 		iosupport.SkipMalformattedLines(),
 		iosupport.DropEmptyIndexedFields(),
-		iosupport.SwapperOpts(limit, fmt.Sprintf("/tmp/tsv_swap_%d", time.Now().Nanosecond())))
+		iosupport.SwapperOpts(limit, fmt.Sprintf("/tmp/tsv_swap_%d", time.Now().Nanosecond())),
+	}
+	if context.IsSet("H") {
+		options = append(options, iosupport.Header(context.Bool("H")))
+	}
+	if separator != "" {
+		options = append(options, iosupport.Separator(separator))
+	}
+	indexer := iosupport.NewTsvIndexer(sc, options...)
 	defer indexer.CloseIO()
+	fmt.Printf("Separator: %q, header: %t\n", indexer.SeparatorBytes, indexer.Header)
 
 	elapsed := time.Since(start)
 	fmt.Printf("Initialization took %s\n\n", elapsed)
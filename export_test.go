@@ -11,3 +11,8 @@ func SetToken(tp *TsvParser, token []byte) {
 func ParseFields(tp *TsvParser) [][]byte {
 	return tp.parseFields()
 }
+
+// SetRow sets the current row of the given TsvParser (reused by ParseFields with ReuseRow)
+func SetRow(tp *TsvParser, row [][]byte) {
+	tp.row = row
+}
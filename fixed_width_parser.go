@@ -0,0 +1,128 @@
+package iosupport
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// A FixedWidthParser reads records from a fixed-width file (e.g. mainframe or bank extracts).
+// Each column is defined by its name and its range in the line:
+//
+//	parser := iosupport.NewFixedWidthParser(sc,
+//		iosupport.FixedWidthColumn{Name: "account", Start: 0, End: 10},
+//		iosupport.FixedWidthColumn{Name: "amount", Start: 10, End: 22},
+//		iosupport.FixedWidthColumn{Name: "label", Start: 22}) // Until the end of the line
+//	for parser.ScanRow() {
+//		println(string(parser.Row()[0]))
+//	}
+//
+// The ranges are byte indexes unless Runes is true. The padding spaces of the fields are trimmed unless KeepPadding is true.
+// A field beyond the end of a short line is empty. The rows do not reference the buffers of the Scanner, so they can be kept.
+//
+// It has the same surface as TsvParser so it can be used as the row source of a TsvIndexer (see RowSource option),
+// the column names are then the names used by the Fields option.
+type FixedWidthParser struct {
+	*Scanner
+	err         error // Sticky error.
+	Runes       bool  // the column ranges are rune indexes
+	KeepPadding bool  // the padding spaces of the fields are kept
+	columns     []FixedWidthColumn
+	header      []string
+	row         [][]byte
+}
+
+// A FixedWidthColumn defines a column of a fixed-width file.
+// The first byte (or rune) of the line is 0. The End is excluded, zero means until the end of the line.
+type FixedWidthColumn struct {
+	Name  string
+	Start int
+	End   int
+}
+
+// NewFixedWidthParser instanciates a new FixedWidthParser.
+func NewFixedWidthParser(sc *Scanner, columns ...FixedWidthColumn) *FixedWidthParser {
+	sc.Reset()
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+	return &FixedWidthParser{
+		Scanner: sc,
+		columns: columns,
+		header:  header,
+	}
+}
+
+// Err returns the first non-EOF error that was encountered by the Scanner.
+func (fp *FixedWidthParser) Err() error {
+	return fp.err
+}
+
+// Header returns the names of the columns.
+func (fp *FixedWidthParser) Header() []string {
+	return fp.header
+}
+
+// Row returns a slice of fields for the current row.
+func (fp *FixedWidthParser) Row() [][]byte {
+	return fp.row
+}
+
+// Reset resets parser and its underliying scanner. It freeing the memory.
+func (fp *FixedWidthParser) Reset() {
+	fp.Scanner.Reset()
+	fp.row = make([][]byte, 0)
+}
+
+// ScanRow advances the fixed-width parser to the next row.
+func (fp *FixedWidthParser) ScanRow() bool {
+	b := fp.ScanLine()
+	if fp.Scanner.Err() != nil {
+		fp.err = fp.Scanner.Err()
+		b = !fp.IsLineEmpty()
+	}
+
+	if b {
+		line := append([]byte{}, fp.trimDelimiter(fp.Bytes())...) // The kept fields must not reference the buffers of the scanner
+		fp.row = fp.parseFields(line)
+	}
+
+	return b
+}
+
+// parseFields cuts the given line according to the columns ranges.
+func (fp *FixedWidthParser) parseFields(line []byte) [][]byte {
+	row := make([][]byte, len(fp.columns))
+	for i, column := range fp.columns {
+		start, end := column.Start, column.End
+		if fp.Runes {
+			start, end = runeIndex(line, start), runeIndex(line, end)
+		}
+		if end <= 0 || end > len(line) {
+			end = len(line)
+		}
+		if start > end {
+			start = end
+		}
+
+		field := line[start:end]
+		if !fp.KeepPadding {
+			field = bytes.Trim(field, " ")
+		}
+		row[i] = field
+	}
+	return row
+}
+
+// runeIndex returns the byte index of the nth rune of the line (0 stays 0 for "until the end of the line").
+func runeIndex(line []byte, n int) int {
+	i := 0
+	for ; n > 0 && i < len(line); n-- {
+		_, size := utf8.DecodeRune(line[i:])
+		i += size
+	}
+	if n > 0 {
+		return len(line) + n // Beyond the end of the line
+	}
+	return i
+}
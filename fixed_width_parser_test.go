@@ -0,0 +1,80 @@
+package iosupport_test
+
+import (
+	. "github.com/mdouchement/iosupport"
+	"github.com/mdouchement/stringio"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FixedWidthParser", func() {
+	var columns = []FixedWidthColumn{
+		{Name: "account", Start: 0, End: 6},
+		{Name: "amount", Start: 6, End: 12},
+		{Name: "label", Start: 12},
+	}
+
+	Describe("#ScanRow", func() {
+		Context("with byte ranges", func() {
+			var sc = NewScanner(stringio.NewFromString("A0001     42rent\r\nB0002  1337\nC3\n"))
+			var subject = NewFixedWidthParser(sc, columns...)
+
+			var actual = [][]string{}
+			var offsets = []uint64{}
+			var limits = []uint32{}
+			for subject.ScanRow() {
+				check(subject.Err())
+				actual = append(actual, toStringSlice(subject.Row()))
+				offsets = append(offsets, subject.Offset())
+				limits = append(limits, subject.Limit())
+			}
+			check(subject.Err())
+
+			It("cuts the lines and trims the padding", func() {
+				Expect(actual).To(Equal([][]string{
+					{"A0001", "42", "rent"},
+					{"B0002", "1337", ""},
+					{"C3", "", ""},
+				}))
+			})
+
+			It("has the offsets and limits of its inner scanner", func() {
+				Expect(offsets).To(Equal([]uint64{0, 18, 30}))
+				Expect(limits).To(Equal([]uint32{18, 12, 3}))
+			})
+
+			It("returns the column names", func() {
+				Expect(subject.Header()).To(Equal([]string{"account", "amount", "label"}))
+			})
+		})
+
+		Context("when the rows are kept", func() {
+			var sc = NewScanner(stringio.NewFromString("A0001     42rent\nB0002  1337\n"))
+			var subject = NewFixedWidthParser(sc, columns...)
+
+			var rows = [][][]byte{}
+			for subject.ScanRow() {
+				check(subject.Err())
+				rows = append(rows, subject.Row())
+			}
+
+			It("does not alias the fields of the previous rows", func() {
+				Expect(toStringSlice(rows[0])).To(Equal([]string{"A0001", "42", "rent"}))
+				Expect(toStringSlice(rows[1])).To(Equal([]string{"B0002", "1337", ""}))
+			})
+		})
+
+		Context("with rune ranges", func() {
+			var sc = NewScanner(stringio.NewFromString("Éa  é|x\n"))
+			var subject = NewFixedWidthParser(sc, FixedWidthColumn{Name: "c1", Start: 0, End: 4}, FixedWidthColumn{Name: "c2", Start: 4, End: 5})
+			subject.Runes = true
+			subject.KeepPadding = true
+
+			It("cuts the lines on runes", func() {
+				Expect(subject.ScanRow()).To(BeTrue())
+				Expect(toStringSlice(subject.Row())).To(Equal([]string{"Éa  ", "é"}))
+			})
+		})
+	})
+})
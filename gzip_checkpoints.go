@@ -0,0 +1,660 @@
+package iosupport
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// The gzip checkpoints are built like zran.c (zlib examples): the file is inflated once and, every interval,
+// the position of the next deflate block is recorded with the last 32 KiB of uncompressed data (the window).
+// The decompression is then resumed from a checkpoint with compress/flate and the window as dictionary.
+// compress/flate only reads from a byte boundary, so the bits that precede the deflate block in its first byte
+// are replaced by an empty deflate block that ends at the same bit position (see deflateAlignment).
+
+const (
+	// DefaultCheckpointInterval is the number of uncompressed bytes between two checkpoints inside a gzip member.
+	DefaultCheckpointInterval uint64 = 1 << 20
+	// windowSize is the maximum distance of a deflate back-reference.
+	windowSize = 32 << 10
+)
+
+var errCorruptDeflate = errors.New("gzip: corrupt deflate stream")
+
+// deflate tables (RFC 1951 section 3.2.5)
+var (
+	lengthBase  = [29]uint16{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [29]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = [30]uint16{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = [30]uint8{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+	// Order of the code length code lengths of a dynamic block.
+	codeLengthOrder = [19]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+)
+
+func buildGzipCheckpoints(f FileReader, index *CheckpointIndex, interval uint64, appendCheckpoint func(uint64, uint64)) error {
+	span := interval
+	if span == 0 {
+		span = DefaultCheckpointInterval
+	}
+	s := &gzipScanner{r: bufio.NewReader(io.NewSectionReader(f, 0, 1<<62))}
+
+	for {
+		memberOffset := s.bitOffset() / 8
+		if err := s.readHeader(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		nbCheckpoints := len(index.Checkpoints)
+		appendCheckpoint(index.Size, memberOffset)
+		memberCheckpoint := len(index.Checkpoints) > nbCheckpoints
+
+		s.resetMember()
+		var windows []int // Checkpoints of the member that need the offset of the next member
+		for final := false; !final; {
+			size := s.size
+			l := len(index.Checkpoints)
+			checkpoint := size > 0 && (l == 0 || index.Size+size-index.Checkpoints[l-1].Offset >= span)
+			if checkpoint {
+				bitOffset := s.bitOffset()
+				index.Checkpoints = append(index.Checkpoints, Checkpoint{
+					Offset:           index.Size + size,
+					CompressedOffset: bitOffset / 8,
+					Bits:             uint8(bitOffset % 8),
+					Window:           s.window(),
+				})
+			}
+
+			var err error
+			if final, err = s.inflateBlock(); err != nil {
+				return err
+			}
+			switch {
+			case checkpoint && s.size == size:
+				index.Checkpoints = index.Checkpoints[:l] // Empty block (e.g. the final block of a flushed member)
+			case checkpoint:
+				windows = append(windows, l)
+			}
+		}
+		if err := s.readTrailer(); err != nil {
+			return err
+		}
+
+		for _, i := range windows {
+			index.Checkpoints[i].NextMember = s.bitOffset() / 8
+		}
+		if s.size == 0 && memberCheckpoint {
+			// Empty member, the next one starts at the same uncompressed offset
+			index.Checkpoints = index.Checkpoints[:nbCheckpoints]
+		}
+		index.Size += s.size
+	}
+}
+
+// openGzipCheckpoint returns the uncompressed content of the file from the given checkpoint (that has a window)
+// until the end of the file.
+func openGzipCheckpoint(f FileReader, cp Checkpoint) (io.ReadCloser, error) {
+	stream := deflateAlignment(cp.Bits)
+	offset := int64(cp.CompressedOffset)
+	if cp.Bits > 0 {
+		// The first byte is shared by the empty block and the checkpoint's block
+		b := make([]byte, 1)
+		if _, err := f.ReadAt(b, offset); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		stream[len(stream)-1] |= b[0] &^ (1<<cp.Bits - 1)
+		offset++
+	}
+
+	r := io.MultiReader(bytes.NewReader(stream), io.NewSectionReader(f, offset, 1<<62))
+	return &gzipCheckpointReader{
+		deflate: flate.NewReaderDict(bufio.NewReader(r), cp.Window),
+		f:       f,
+		next:    cp.NextMember,
+	}, nil
+}
+
+// deflateAlignment returns an empty deflate block which is followed by bits bits (0 to 7) in its last byte.
+// It is a dynamic block where only the number of code length codes (HCLEN) changes, each code length code
+// takes 3 bits so any alignment can be reached.
+func deflateAlignment(bits uint8) []byte {
+	hclen := 3 * (int(bits) + 1) % 8 // The block is 295 + 3*hclen bits long
+	if hclen == 0 {
+		hclen = 8
+	}
+
+	w := &bitWriter{}
+	w.writeBits(0, 1)     // BFINAL
+	w.writeBits(2, 2)     // BTYPE: dynamic Huffman codes
+	w.writeBits(0, 5)     // HLIT: 257 literal/length codes
+	w.writeBits(0, 5)     // HDIST: 1 distance code
+	w.writeBits(hclen, 4) // HCLEN: hclen + 4 code length codes
+	for _, symbol := range codeLengthOrder[:hclen+4] {
+		// Code lengths alphabet: 0 -> `0' and 8 -> `1'
+		if symbol == 0 || symbol == 8 {
+			w.writeBits(1, 3)
+		} else {
+			w.writeBits(0, 3)
+		}
+	}
+	// Literal 0 is unused, the literals 1 to 255 and the end of block are 8 bits long (complete code).
+	// The distance code is empty.
+	w.writeBits(0, 1)
+	for i := 0; i < 256; i++ {
+		w.writeBits(1, 1)
+	}
+	w.writeBits(0, 1)
+	w.writeBits(0xff, 8) // End of block: last code of the literal/length alphabet
+	return w.buf
+}
+
+// gzipCheckpointReader reads the gzip member of a checkpoint then the following members.
+type gzipCheckpointReader struct {
+	deflate io.ReadCloser
+	f       FileReader
+	next    uint64       // Compressed offset of the next member.
+	members *gzip.Reader // Reader of the members that follow the one of the checkpoint.
+}
+
+func (r *gzipCheckpointReader) Read(b []byte) (int, error) {
+	if r.members != nil {
+		return r.members.Read(b)
+	}
+
+	n, err := r.deflate.Read(b)
+	if err != io.EOF {
+		return n, err
+	}
+	members, err := gzip.NewReader(bufio.NewReader(io.NewSectionReader(r.f, int64(r.next), 1<<62)))
+	if err != nil {
+		return n, err // io.EOF when there is no more member
+	}
+	r.members = members
+	if n > 0 {
+		return n, nil
+	}
+	return r.members.Read(b)
+}
+
+func (r *gzipCheckpointReader) Close() error {
+	if r.members != nil {
+		r.members.Close()
+	}
+	return r.deflate.Close()
+}
+
+// ------------------ //
+// Inflate stuff      //
+// ------------------ //
+
+// gzipScanner inflates the members of a gzip file and keeps track of the position of the deflate blocks.
+type gzipScanner struct {
+	r      *bufio.Reader
+	off    uint64 // Compressed offset of the next byte read from r.
+	b      uint64 // Bit buffer.
+	nb     uint   // Number of bits in b.
+	hist   []byte // Uncompressed data of the current member (only the last windowSize bytes are kept).
+	hashed int    // Number of bytes of hist already hashed.
+	crc    uint32 // CRC-32 of the current member.
+	size   uint64 // Uncompressed size of the current member.
+	lit    huffman
+	dist   huffman
+}
+
+// bitOffset returns the offset in bits of the next bit to be read.
+func (s *gzipScanner) bitOffset() uint64 {
+	return s.off*8 - uint64(s.nb)
+}
+
+func (s *gzipScanner) moreBits() error {
+	c, err := s.r.ReadByte()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	s.off++
+	s.b |= uint64(c) << s.nb
+	s.nb += 8
+	return nil
+}
+
+func (s *gzipScanner) bits(n uint) (int, error) {
+	for s.nb < n {
+		if err := s.moreBits(); err != nil {
+			return 0, err
+		}
+	}
+	v := int(s.b & (1<<n - 1))
+	s.b >>= n
+	s.nb -= n
+	return v, nil
+}
+
+// bytes reads len(p) bytes after the current byte boundary.
+func (s *gzipScanner) bytes(p []byte) error {
+	s.b >>= s.nb % 8
+	s.nb -= s.nb % 8
+	for len(p) > 0 && s.nb > 0 {
+		p[0] = byte(s.b)
+		p = p[1:]
+		s.b >>= 8
+		s.nb -= 8
+	}
+
+	n, err := io.ReadFull(s.r, p)
+	s.off += uint64(n)
+	return unexpectedEOF(err)
+}
+
+// readHeader reads a gzip member header (RFC 1952 section 2.3), it returns io.EOF at the end of the file.
+func (s *gzipScanner) readHeader() error {
+	if s.nb == 0 {
+		if _, err := s.r.Peek(1); err == io.EOF {
+			return io.EOF
+		}
+	}
+
+	header := make([]byte, 10)
+	if err := s.bytes(header); err != nil {
+		return err
+	}
+	if header[0] != gzipMagic[0] || header[1] != gzipMagic[1] || header[2] != 8 {
+		return gzip.ErrHeader
+	}
+
+	flags := header[3]
+	if flags&0x04 != 0 { // FEXTRA
+		if err := s.bytes(header[:2]); err != nil {
+			return err
+		}
+		if err := s.bytes(make([]byte, int(header[0])|int(header[1])<<8)); err != nil {
+			return err
+		}
+	}
+	for _, flag := range []byte{0x08, 0x10} { // FNAME and FCOMMENT are zero-terminated
+		for flags&flag != 0 {
+			if err := s.bytes(header[:1]); err != nil {
+				return err
+			}
+			if header[0] == 0 {
+				break
+			}
+		}
+	}
+	if flags&0x02 != 0 { // FHCRC
+		return s.bytes(header[:2])
+	}
+	return nil
+}
+
+// readTrailer reads and checks the CRC-32 and the size of the member.
+func (s *gzipScanner) readTrailer() error {
+	s.hash()
+	trailer := make([]byte, 8)
+	if err := s.bytes(trailer); err != nil {
+		return err
+	}
+	crc := uint32(trailer[0]) | uint32(trailer[1])<<8 | uint32(trailer[2])<<16 | uint32(trailer[3])<<24
+	size := uint32(trailer[4]) | uint32(trailer[5])<<8 | uint32(trailer[6])<<16 | uint32(trailer[7])<<24
+	if crc != s.crc || size != uint32(s.size) {
+		return gzip.ErrChecksum
+	}
+	return nil
+}
+
+func (s *gzipScanner) resetMember() {
+	s.hist = s.hist[:0]
+	s.hashed = 0
+	s.crc = 0
+	s.size = 0
+}
+
+// window returns a copy of the last windowSize bytes of the member.
+func (s *gzipScanner) window() []byte {
+	window := s.hist
+	if len(window) > windowSize {
+		window = window[len(window)-windowSize:]
+	}
+	return append([]byte(nil), window...)
+}
+
+// hash updates the CRC-32 with the bytes of hist that are not yet hashed.
+func (s *gzipScanner) hash() {
+	s.crc = crc32.Update(s.crc, crc32.IEEETable, s.hist[s.hashed:])
+	s.hashed = len(s.hist)
+}
+
+// compact drops the bytes of hist that are no longer needed by the back-references.
+func (s *gzipScanner) compact() {
+	if len(s.hist) < 4*windowSize {
+		return
+	}
+	s.hash()
+	n := copy(s.hist, s.hist[len(s.hist)-windowSize:])
+	s.hist = s.hist[:n]
+	s.hashed = n
+}
+
+// inflateBlock inflates the next deflate block and says if it is the final one.
+func (s *gzipScanner) inflateBlock() (bool, error) {
+	final, err := s.bits(1)
+	if err != nil {
+		return false, err
+	}
+	typ, err := s.bits(2)
+	if err != nil {
+		return false, err
+	}
+
+	switch typ {
+	case 0:
+		err = s.storedBlock()
+	case 1:
+		s.fixedCodes()
+		err = s.huffmanBlock()
+	case 2:
+		if err = s.dynamicCodes(); err == nil {
+			err = s.huffmanBlock()
+		}
+	default:
+		err = errCorruptDeflate
+	}
+	return final == 1, err
+}
+
+func (s *gzipScanner) storedBlock() error {
+	header := make([]byte, 4)
+	if err := s.bytes(header); err != nil {
+		return err
+	}
+	n := int(header[0]) | int(header[1])<<8
+	if nn := int(header[2]) | int(header[3])<<8; n != ^nn&0xffff {
+		return errCorruptDeflate
+	}
+
+	start := len(s.hist)
+	s.hist = append(s.hist, make([]byte, n)...)
+	if err := s.bytes(s.hist[start:]); err != nil {
+		return err
+	}
+	s.produced(n)
+	return nil
+}
+
+// produced accounts the n bytes appended to hist.
+func (s *gzipScanner) produced(n int) {
+	s.size += uint64(n)
+	s.compact()
+}
+
+func (s *gzipScanner) fixedCodes() {
+	lengths := make([]uint8, 288+30)
+	for i := range lengths {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		case i < 288:
+			lengths[i] = 8
+		default:
+			lengths[i] = 5
+		}
+	}
+	s.lit.init(lengths[:288])
+	s.dist.init(lengths[288:])
+}
+
+func (s *gzipScanner) dynamicCodes() error {
+	header, err := s.bits(14)
+	if err != nil {
+		return err
+	}
+	nlit := header&0x1f + 257
+	ndist := header>>5&0x1f + 1
+	nclen := header>>10 + 4
+	if nlit > 286 || ndist > 30 {
+		return errCorruptDeflate
+	}
+
+	lengths := make([]uint8, 19)
+	for _, symbol := range codeLengthOrder[:nclen] {
+		v, err := s.bits(3)
+		if err != nil {
+			return err
+		}
+		lengths[symbol] = uint8(v)
+	}
+	var codeLengths huffman
+	if err := codeLengths.init(lengths); err != nil {
+		return err
+	}
+
+	lengths = make([]uint8, nlit+ndist)
+	for i := 0; i < len(lengths); {
+		symbol, err := s.decode(&codeLengths)
+		if err != nil {
+			return err
+		}
+		if symbol < 16 {
+			lengths[i] = uint8(symbol)
+			i++
+			continue
+		}
+
+		var length uint8
+		var repeat int
+		switch symbol {
+		case 16:
+			if i == 0 {
+				return errCorruptDeflate
+			}
+			length = lengths[i-1]
+			repeat, err = s.bits(2)
+			repeat += 3
+		case 17:
+			repeat, err = s.bits(3)
+			repeat += 3
+		default:
+			repeat, err = s.bits(7)
+			repeat += 11
+		}
+		if err != nil {
+			return err
+		}
+		if i+repeat > len(lengths) {
+			return errCorruptDeflate
+		}
+		for ; repeat > 0; repeat-- {
+			lengths[i] = length
+			i++
+		}
+	}
+	if lengths[256] == 0 {
+		return errCorruptDeflate // No end of block
+	}
+
+	if err := s.lit.init(lengths[:nlit]); err != nil {
+		return err
+	}
+	return s.dist.init(lengths[nlit:])
+}
+
+func (s *gzipScanner) huffmanBlock() error {
+	n := len(s.hist)
+	for {
+		if len(s.hist)-n >= windowSize {
+			// Long block, hist is compacted before the end of the block
+			s.produced(len(s.hist) - n)
+			n = len(s.hist)
+		}
+
+		symbol, err := s.decode(&s.lit)
+		if err != nil {
+			return err
+		}
+		if symbol < 256 {
+			s.hist = append(s.hist, byte(symbol))
+			continue
+		}
+		if symbol == 256 {
+			s.produced(len(s.hist) - n)
+			return nil
+		}
+
+		// Back-reference
+		symbol -= 257
+		if symbol >= len(lengthBase) {
+			return errCorruptDeflate
+		}
+		extra, err := s.bits(uint(lengthExtra[symbol]))
+		if err != nil {
+			return err
+		}
+		length := int(lengthBase[symbol]) + extra
+
+		if symbol, err = s.decode(&s.dist); err != nil {
+			return err
+		}
+		if symbol >= len(distBase) {
+			return errCorruptDeflate
+		}
+		if extra, err = s.bits(uint(distExtra[symbol])); err != nil {
+			return err
+		}
+		distance := int(distBase[symbol]) + extra
+		if distance > len(s.hist) {
+			return errCorruptDeflate
+		}
+
+		for i := len(s.hist) - distance; length > 0; length-- {
+			s.hist = append(s.hist, s.hist[i])
+			i++
+		}
+	}
+}
+
+// decode reads the next symbol of the given code.
+func (s *gzipScanner) decode(h *huffman) (int, error) {
+	for s.nb < huffmanFastBits {
+		if s.moreBits() != nil {
+			break // The last symbols are decoded bit by bit
+		}
+	}
+	if e := h.fast[s.b&(1<<huffmanFastBits-1)]; e != 0 && uint(e&0xf) <= s.nb {
+		s.b >>= e & 0xf
+		s.nb -= uint(e & 0xf)
+		return int(e >> 4), nil
+	}
+
+	// Slow path (see puff.c in zlib contribs)
+	code, first, index := 0, 0, 0
+	for length := 1; length < len(h.count); length++ {
+		bit, err := s.bits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= bit
+		count := int(h.count[length])
+		if code-count < first {
+			return int(h.symbols[index+code-first]), nil
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0, errCorruptDeflate
+}
+
+// huffmanFastBits is the number of bits of the lookup table of the short codes.
+const huffmanFastBits = 9
+
+// huffman is a canonical Huffman code.
+type huffman struct {
+	count   [16]uint16 // Number of codes of each length.
+	symbols []uint16   // Symbols ordered by code.
+	// fast maps the next huffmanFastBits bits to symbol<<4|length for the short codes.
+	fast [1 << huffmanFastBits]uint16
+}
+
+// init builds the code from the code lengths of the symbols.
+// An incomplete code is allowed (e.g. a single distance code).
+func (h *huffman) init(lengths []uint8) error {
+	h.count = [16]uint16{}
+	for _, length := range lengths {
+		h.count[length]++
+	}
+	h.count[0] = 0
+
+	left := 1
+	var offsets [16]uint16
+	for length := 1; length < len(h.count); length++ {
+		left = left<<1 - int(h.count[length])
+		if left < 0 {
+			return errCorruptDeflate // Over-subscribed
+		}
+		if length+1 < len(offsets) {
+			offsets[length+1] = offsets[length] + h.count[length]
+		}
+	}
+
+	h.symbols = h.symbols[:0]
+	for range lengths {
+		h.symbols = append(h.symbols, 0)
+	}
+	for symbol, length := range lengths {
+		if length != 0 {
+			h.symbols[offsets[length]] = uint16(symbol)
+			offsets[length]++
+		}
+	}
+
+	h.fast = [1 << huffmanFastBits]uint16{}
+	code, index := 0, 0
+	for length := 1; length <= huffmanFastBits; length++ {
+		for i := 0; i < int(h.count[length]); i++ {
+			entry := h.symbols[index]<<4 | uint16(length)
+			for j := reverseBits(code, length); j < len(h.fast); j += 1 << uint(length) {
+				h.fast[j] = entry
+			}
+			code++
+			index++
+		}
+		code <<= 1
+	}
+	return nil
+}
+
+// reverseBits reverses the n low bits of v (the codes are packed starting with their most significant bit).
+func reverseBits(v, n int) int {
+	r := 0
+	for i := 0; i < n; i++ {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+	return r
+}
+
+// bitWriter writes bits starting with the least significant bit of each byte like deflate.
+type bitWriter struct {
+	buf []byte
+	nb  uint // Number of bits written in the last byte.
+}
+
+func (w *bitWriter) writeBits(v int, n uint) {
+	for ; n > 0; n-- {
+		if w.nb%8 == 0 {
+			w.buf = append(w.buf, 0)
+			w.nb = 0
+		}
+		w.buf[len(w.buf)-1] |= byte(v&1) << w.nb
+		w.nb++
+		v >>= 1
+	}
+}
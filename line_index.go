@@ -0,0 +1,378 @@
+package iosupport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+// The line index is a sidecar file that stores the offsets of the lines of a file.
+// It allows to read any line of a very large file without scanning it.
+// Main usages:
+//
+// // Build the index once
+// idxfile, _ := os.Create("my_file.tsv.lidx")
+// iosupport.BuildLineIndex(iosupport.NewScanner(file), idxfile)
+//
+// // Page through the file
+// idxfile, _ := os.Open("my_file.tsv.lidx")
+// r, _ := iosupport.NewIndexedReader(file, idxfile)
+// lines, _ := r.ReadLines(1000001, 1000100)
+//
+// The lines are numbered like Scanner.Line, so the lines skipped by the Scanner (see SkipLongLines) are not indexed
+// and the following ones keep their number.
+//
+// The lines are stored by blocks of consecutive lines. Each line is encoded with three uvarints:
+// its gap with the number and with the end of the previous line (0 unless lines are skipped by the Scanner) and its limit.
+// A footer holds the line terminator of the Scanner (see SetDelimiter) and locates the blocks,
+// so only one block is read for finding a line.
+
+const (
+	lineIndexVersion   byte = 2
+	lineIndexBlockSize      = 1024 // Number of lines per block.
+	lineIndexTrailer        = 16   // Footer position and number of lines.
+)
+
+var (
+	// ErrInvalidLineIndex -> the line index cannot be read
+	ErrInvalidLineIndex = errors.New("invalid line index")
+	// ErrLineOutOfRange -> the line does not exist in the indexed file
+	ErrLineOutOfRange = errors.New("line out of range")
+
+	lineIndexMagic = []byte("IOLI")
+)
+
+type (
+	// An IndexedReader reads the lines of a file from its line index.
+	IndexedReader struct {
+		sync.Mutex
+		f      FileReader // The indexed file.
+		index  FileReader // The line index.
+		lines  int        // Number of indexed lines.
+		blocks []lineBlock
+		// Line terminator in the encoding of the file
+		delim  []byte // Delimiter defined by Scanner.SetDelimiter, nil means any newline sequence.
+		lf, cr []byte
+		// Last read block
+		block   int
+		numbers []int
+		offsets []uint64
+		limits  []uint32
+	}
+
+	lineBlock struct {
+		line     int    // Number of the line that precedes the block.
+		base     uint64 // Offset of the end of the line that precedes the block.
+		position uint64 // Position of the block in the line index.
+	}
+)
+
+// BuildLineIndex scans the whole file and writes the offsets of its lines into w.
+// The Scanner is reset before and after the scan, its options (e.g. SkipLongLines, SeekOffset, SetDelimiter, SetEncoding)
+// are honored.
+func BuildLineIndex(sc *Scanner, w io.Writer) error {
+	sc.Reset()
+	defer sc.Reset()
+
+	bw := bufio.NewWriter(w)
+	var position uint64
+	varint := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(varint, v)
+		bw.Write(varint[:n])
+		position += uint64(n)
+	}
+
+	bw.Write(lineIndexMagic)
+	bw.WriteByte(lineIndexVersion)
+	position += uint64(len(lineIndexMagic) + 1)
+
+	var lines, end uint64
+	var line int
+	blocks := []lineBlock{}
+	for sc.ScanLine() {
+		if lines%lineIndexBlockSize == 0 {
+			blocks = append(blocks, lineBlock{line: line, base: end, position: position})
+		}
+		putUvarint(uint64(sc.Line() - line - 1))
+		putUvarint(sc.Offset() - end)
+		putUvarint(uint64(sc.Limit()))
+		line = sc.Line()
+		end = sc.Offset() + uint64(sc.Limit())
+		lines++
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	// Footer
+	footer := position
+	for _, terminator := range [][]byte{sc.encode(sc.delim), sc.encode([]byte{LF}), sc.encode([]byte{CR})} {
+		putUvarint(uint64(len(terminator)))
+		bw.Write(terminator)
+		position += uint64(len(terminator))
+	}
+	putUvarint(uint64(len(blocks)))
+	var previous lineBlock
+	for _, block := range blocks {
+		putUvarint(uint64(block.line - previous.line))
+		putUvarint(block.base - previous.base)
+		putUvarint(block.position - previous.position)
+		previous = block
+	}
+
+	trailer := make([]byte, lineIndexTrailer)
+	binary.LittleEndian.PutUint64(trailer, footer)
+	binary.LittleEndian.PutUint64(trailer[8:], lines)
+	bw.Write(trailer)
+
+	return bw.Flush()
+}
+
+// NewIndexedReader instanciates a new IndexedReader on the given file and its line index.
+func NewIndexedReader(f, index FileReader) (*IndexedReader, error) {
+	size, err := index.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if size < int64(len(lineIndexMagic)+1+lineIndexTrailer) {
+		return nil, ErrInvalidLineIndex
+	}
+
+	header := make([]byte, len(lineIndexMagic)+1)
+	if _, err = index.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(header, lineIndexMagic) || header[len(lineIndexMagic)] != lineIndexVersion {
+		return nil, ErrInvalidLineIndex
+	}
+
+	trailer := make([]byte, lineIndexTrailer)
+	if _, err = index.ReadAt(trailer, size-lineIndexTrailer); err != nil {
+		return nil, err
+	}
+	footer := binary.LittleEndian.Uint64(trailer)
+	lines := binary.LittleEndian.Uint64(trailer[8:])
+	if footer > uint64(size-lineIndexTrailer) {
+		return nil, ErrInvalidLineIndex
+	}
+
+	data := make([]byte, uint64(size-lineIndexTrailer)-footer)
+	if _, err = index.ReadAt(data, int64(footer)); err != nil {
+		return nil, err
+	}
+	br := bytes.NewReader(data)
+	terminators := make([][]byte, 3)
+	for i := range terminators {
+		n, err := binary.ReadUvarint(br)
+		if err != nil || n > uint64(br.Len()) {
+			return nil, ErrInvalidLineIndex
+		}
+		terminators[i] = make([]byte, n)
+		br.Read(terminators[i])
+	}
+	if len(terminators[0]) == 0 {
+		terminators[0] = nil // Any newline sequence
+	}
+
+	n, err := binary.ReadUvarint(br)
+	if err != nil || n != (lines+lineIndexBlockSize-1)/lineIndexBlockSize {
+		return nil, ErrInvalidLineIndex
+	}
+
+	var block lineBlock
+	blocks := make([]lineBlock, 0, n+1)
+	for i := uint64(0); i < n; i++ {
+		// Blocks are delta encoded
+		delta, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, ErrInvalidLineIndex
+		}
+		block.line += int(delta)
+		if delta, err = binary.ReadUvarint(br); err != nil {
+			return nil, ErrInvalidLineIndex
+		}
+		block.base += delta
+		if delta, err = binary.ReadUvarint(br); err != nil {
+			return nil, ErrInvalidLineIndex
+		}
+		block.position += delta
+		blocks = append(blocks, block)
+	}
+	blocks = append(blocks, lineBlock{position: footer}) // Bounds the last block
+
+	return &IndexedReader{
+		f:      f,
+		index:  index,
+		lines:  int(lines),
+		blocks: blocks,
+		delim:  terminators[0],
+		lf:     terminators[1],
+		cr:     terminators[2],
+		block:  -1,
+	}, nil
+}
+
+// Len returns the number of indexed lines.
+func (r *IndexedReader) Len() int {
+	return r.lines
+}
+
+// Position returns the offset and the limit (including newline sequence) of the nth line.
+// Lines are numbered like Scanner.Line, the first line is 1. A line skipped by the Scanner is out of range.
+func (r *IndexedReader) Position(n int) (uint64, uint32, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	i, err := r.search(n)
+	if err != nil {
+		return 0, 0, err
+	}
+	if i == r.lines || i/lineIndexBlockSize != r.block || r.numbers[i%lineIndexBlockSize] != n {
+		return 0, 0, ErrLineOutOfRange
+	}
+	return r.offsets[i%lineIndexBlockSize], r.limits[i%lineIndexBlockSize], nil
+}
+
+// ReadLine reads the nth line of the file without its newline sequence.
+// Lines are numbered like Scanner.Line, the first line is 1. A line skipped by the Scanner is out of range.
+func (r *IndexedReader) ReadLine(n int) ([]byte, error) {
+	lines, err := r.ReadLines(n, n)
+	if err != nil {
+		return nil, err
+	}
+	return lines[0], nil
+}
+
+// ReadLines reads the indexed lines from `from' to `to' (included) without their line terminator
+// (the newline sequence or the delimiter defined by Scanner.SetDelimiter).
+// The lines skipped by the Scanner are not returned and `to' is truncated to the last line of the file.
+// The lines are fetched with only one ReadAt on the file, they are not transcoded when the Scanner has an encoding.
+func (r *IndexedReader) ReadLines(from, to int) ([][]byte, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if from < 1 || from > to {
+		return nil, ErrLineOutOfRange
+	}
+	i, err := r.search(from)
+	if err != nil {
+		return nil, err
+	}
+
+	var start uint64
+	offsets := []uint64{}
+	limits := []uint32{}
+	for ; i < r.lines; i++ {
+		if err := r.readBlock(i / lineIndexBlockSize); err != nil {
+			return nil, err
+		}
+		j := i % lineIndexBlockSize
+		if r.numbers[j] > to {
+			break
+		}
+		if len(offsets) == 0 {
+			start = r.offsets[j]
+		}
+		offsets = append(offsets, r.offsets[j]-start)
+		limits = append(limits, r.limits[j])
+	}
+	if len(offsets) == 0 {
+		return nil, ErrLineOutOfRange
+	}
+
+	last := len(offsets) - 1
+	data := make([]byte, offsets[last]+uint64(limits[last]))
+	if _, err := r.f.ReadAt(data, int64(start)); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	lines := make([][]byte, len(offsets))
+	for i, offset := range offsets {
+		lines[i] = r.trimTerminator(data[offset : offset+uint64(limits[i])])
+	}
+	return lines, nil
+}
+
+// search returns the index of the first indexed line numbered n or more (r.lines when there is none)
+// and reads its block.
+func (r *IndexedReader) search(n int) (int, error) {
+	if n < 1 || r.lines == 0 {
+		return 0, ErrLineOutOfRange
+	}
+
+	// The last block that starts before the nth line
+	b := sort.Search(len(r.blocks)-1, func(b int) bool {
+		return r.blocks[b].line >= n
+	}) - 1
+	if b < 0 {
+		b = 0
+	}
+	if err := r.readBlock(b); err != nil {
+		return 0, err
+	}
+
+	i := sort.SearchInts(r.numbers, n)
+	return b*lineIndexBlockSize + i, nil
+}
+
+// trimTerminator removes the line terminator like TrimNewline but in the encoding of the file.
+func (r *IndexedReader) trimTerminator(line []byte) []byte {
+	if r.delim != nil {
+		return bytes.TrimSuffix(line, r.delim)
+	}
+	line = bytes.TrimSuffix(line, r.lf)
+	line = bytes.TrimSuffix(line, r.cr)
+	return bytes.TrimSuffix(line, r.lf)
+}
+
+// readBlock decodes the given block of the line index.
+func (r *IndexedReader) readBlock(b int) error {
+	if b == r.block {
+		return nil
+	}
+	r.block = -1
+
+	data := make([]byte, r.blocks[b+1].position-r.blocks[b].position)
+	if _, err := r.index.ReadAt(data, int64(r.blocks[b].position)); err != nil && err != io.EOF {
+		return err
+	}
+
+	n := r.lines - b*lineIndexBlockSize
+	if n > lineIndexBlockSize {
+		n = lineIndexBlockSize
+	}
+	r.numbers = r.numbers[:0]
+	r.offsets = r.offsets[:0]
+	r.limits = r.limits[:0]
+
+	br := bytes.NewReader(data)
+	line := r.blocks[b].line
+	end := r.blocks[b].base
+	for i := 0; i < n; i++ {
+		skipped, err := binary.ReadUvarint(br)
+		if err != nil {
+			return ErrInvalidLineIndex
+		}
+		gap, err := binary.ReadUvarint(br)
+		if err != nil {
+			return ErrInvalidLineIndex
+		}
+		limit, err := binary.ReadUvarint(br)
+		if err != nil {
+			return ErrInvalidLineIndex
+		}
+		line += int(skipped) + 1
+		r.numbers = append(r.numbers, line)
+		r.offsets = append(r.offsets, end+gap)
+		r.limits = append(r.limits, uint32(limit))
+		end += gap + limit
+	}
+
+	r.block = b
+	return nil
+}
@@ -0,0 +1,135 @@
+package iosupport_test
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/mdouchement/iosupport"
+	"github.com/mdouchement/stringio"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IndexedReader", func() {
+	var lines = []string{}
+	for i := 1; i <= 3000; i++ {
+		lines = append(lines, fmt.Sprintf("line%d", i))
+	}
+	var data = strings.Join(lines, "\r\n")
+
+	var index = stringio.New()
+	check(BuildLineIndex(NewScanner(stringio.NewFromString(data)), index))
+	var subject, err = NewIndexedReader(stringio.NewFromString(data), index)
+	check(err)
+
+	Describe("#Len", func() {
+		It("returns the number of lines", func() {
+			Expect(subject.Len()).To(Equal(3000))
+		})
+	})
+
+	Describe("#ReadLine", func() {
+		It("reads the given line", func() {
+			Expect(subject.ReadLine(1)).To(Equal([]byte("line1")))
+			Expect(subject.ReadLine(2049)).To(Equal([]byte("line2049")))
+			Expect(subject.ReadLine(3000)).To(Equal([]byte("line3000")))
+		})
+
+		It("returns an error when the line does not exist", func() {
+			_, err := subject.ReadLine(0)
+			Expect(err).To(Equal(ErrLineOutOfRange))
+			_, err = subject.ReadLine(3001)
+			Expect(err).To(Equal(ErrLineOutOfRange))
+		})
+	})
+
+	Describe("#ReadLines", func() {
+		It("reads the lines across the blocks", func() {
+			actual, err := subject.ReadLines(1020, 1030)
+			check(err)
+			Expect(toStringSlice(actual)).To(Equal(lines[1019:1030]))
+		})
+
+		It("truncates the range to the last line", func() {
+			actual, err := subject.ReadLines(2999, 3010)
+			check(err)
+			Expect(toStringSlice(actual)).To(Equal([]string{"line2999", "line3000"}))
+		})
+	})
+
+	Describe("#Position", func() {
+		It("returns the offset and the limit of the line", func() {
+			offset, limit, err := subject.Position(3)
+			check(err)
+			Expect(offset).To(BeNumerically("==", 14))
+			Expect(limit).To(BeNumerically("==", 7))
+		})
+	})
+
+	Context("when lines are skipped by the scanner", func() {
+		var data = "\xEF\xBB\xBFline1\n" + strings.Repeat("a", 100) + "\nline3\n"
+		var sc = NewScanner(stringio.NewFromString(data))
+		sc.MaxLineLength(10)
+		sc.SkipLongLines(true)
+
+		var index = stringio.New()
+		check(BuildLineIndex(sc, index))
+		var subject, err = NewIndexedReader(stringio.NewFromString(data), index)
+		check(err)
+
+		It("indexes the read lines", func() {
+			actual, err := subject.ReadLines(1, 3)
+			check(err)
+			Expect(toStringSlice(actual)).To(Equal([]string{"line1", "line3"}))
+		})
+
+		It("numbers the lines like the scanner", func() {
+			Expect(subject.ReadLine(3)).To(Equal([]byte("line3")))
+			_, err := subject.ReadLine(2)
+			Expect(err).To(Equal(ErrLineOutOfRange))
+			_, _, err = subject.Position(2)
+			Expect(err).To(Equal(ErrLineOutOfRange))
+		})
+	})
+
+	Context("when the scanner has a delimiter", func() {
+		var data = "line1\n\x00line2\r\x00line3"
+		var sc = NewScanner(stringio.NewFromString(data))
+		sc.SetDelimiter(NULDelimiter)
+
+		var index = stringio.New()
+		check(BuildLineIndex(sc, index))
+		var subject, err = NewIndexedReader(stringio.NewFromString(data), index)
+		check(err)
+
+		It("trims the delimiter", func() {
+			actual, err := subject.ReadLines(1, 3)
+			check(err)
+			Expect(toStringSlice(actual)).To(Equal([]string{"line1\n", "line2\r", "line3"}))
+		})
+	})
+
+	Context("when the file is encoded", func() {
+		var data = "\xFF\xFEl\x001\x00\r\x00\n\x00l\x002\x00\n\x00"
+		var sc = NewScanner(stringio.NewFromString(data))
+
+		var index = stringio.New()
+		check(BuildLineIndex(sc, index))
+		var subject, err = NewIndexedReader(stringio.NewFromString(data), index)
+		check(err)
+
+		It("trims the encoded newline sequence", func() {
+			actual, err := subject.ReadLines(1, 2)
+			check(err)
+			Expect(toStringSlice(actual)).To(Equal([]string{"l\x001\x00", "l\x002\x00"}))
+		})
+	})
+
+	Context("when the index is invalid", func() {
+		It("returns an error", func() {
+			_, err := NewIndexedReader(stringio.NewFromString(data), stringio.NewFromString("IOCI\x01"+strings.Repeat("\x00", 16)))
+			Expect(err).To(Equal(ErrInvalidLineIndex))
+		})
+	})
+})
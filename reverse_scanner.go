@@ -0,0 +1,209 @@
+package iosupport
+
+import (
+	"bytes"
+	"io"
+)
+
+// This ReverseScanner reads a file line by line from its end (like tac command).
+// It only reads the needed blocks of the file, so the last lines of a very large file are quickly reached.
+// Main usages:
+//
+// sc := supports.NewReverseScanner(file)
+// for sc.ScanLine() {
+//   println(sc.Text())
+//   println(sc.Offset())
+// }
+//
+// // tail -n 10
+// sc := supports.NewReverseScanner(file)
+// lines := []string{}
+// for i := 0; i < 10 && sc.ScanLine(); i++ {
+//   lines = append([]string{sc.Text()}, lines...)
+// }
+//
+// The byte order mark is skipped like by the Scanner, but the lines are not transcoded.
+//
+// /!\ Warning: only LF and CRLF newline sequences are supported, a lone CR is a part of the line.
+
+// DefaultReverseBlockSize is the number of bytes read at once by a ReverseScanner.
+const DefaultReverseBlockSize = 64 << 10
+
+// ReverseScanner contains all stuff for reading a file backward.
+type ReverseScanner struct {
+	f         FileReader // The file provided by the client.
+	BlockSize int        // Number of bytes read at once (DefaultReverseBlockSize when not positive).
+	keepnls   bool       // Keep the newline sequence in returned strings
+	size      int64      // Size of the file (-1 when not yet known).
+	bom       []byte     // Byte order mark found at the beginning of the file.
+	window    []byte     // Read bytes that are not yet returned, it ends at the offset of the last read line.
+	wstart    int64      // Offset of the first byte of the window.
+	token     []byte     // Last read line.
+	err       error      // Sticky error.
+	line      int        // index of current read line (from the end of the file).
+	offset    uint64     // Offset of the start of the read line.
+	limit     uint32     // Length of the read line including newline sequence.
+}
+
+// NewReverseScanner instanciates a ReverseScanner
+func NewReverseScanner(f FileReader) *ReverseScanner {
+	return &ReverseScanner{
+		f:         f,
+		BlockSize: DefaultReverseBlockSize,
+		size:      -1,
+	}
+}
+
+// KeepNewlineSequence keeps the newline sequence in read lines.
+func (s *ReverseScanner) KeepNewlineSequence(b bool) {
+	s.keepnls = b
+}
+
+// Bytes returns the most recent line generated by a call to ScanLine.
+func (s *ReverseScanner) Bytes() []byte {
+	return s.token
+}
+
+// Text returns the most recent line generated by a call to ScanLine
+// as a newly allocated string holding its bytes.
+func (s *ReverseScanner) Text() string {
+	return string(s.token)
+}
+
+// Err returns the first non-EOF error that was encountered by the ReverseScanner.
+func (s *ReverseScanner) Err() error {
+	if s.err == nil || s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// Line return the index of the current line counted from the end of the file (the last line is 1).
+func (s *ReverseScanner) Line() int {
+	return s.line
+}
+
+// Offset return the byte offset of the current line.
+func (s *ReverseScanner) Offset() uint64 {
+	return s.offset
+}
+
+// Limit return the byte length of the current line including newline sequence.
+func (s *ReverseScanner) Limit() uint32 {
+	return s.limit
+}
+
+// ScanLine moves the ReverseScanner to the previous line, which will then be
+// available through the Bytes or Text method. It returns false when the
+// scan stops, either by reaching the beginning of the input or an error.
+func (s *ReverseScanner) ScanLine() bool {
+	if s.size < 0 {
+		if err := s.init(); err != nil {
+			s.err = err
+			return false
+		}
+	}
+
+	if s.wstart == s.start() && len(s.window) == 0 {
+		s.err = io.EOF
+		return false
+	}
+
+	if len(s.window) == 0 {
+		if err := s.readBlock(); err != nil {
+			s.err = err
+			return false
+		}
+	}
+
+	// The newline character that ends the line is not the beginning of the line
+	tail := 0
+	if s.window[len(s.window)-1] == LF {
+		tail = 1
+	}
+
+	// Loop until the beginning of the line is found.
+	i := bytes.LastIndexByte(s.window[:len(s.window)-tail], LF)
+	for i < 0 && s.wstart > s.start() {
+		if err := s.readBlock(); err != nil {
+			s.err = err
+			return false
+		}
+		i = bytes.LastIndexByte(s.window[:len(s.window)-tail], LF)
+	}
+
+	start := i + 1
+	line := s.window[start:]
+	s.window = s.window[:start]
+	s.offset = uint64(s.wstart) + uint64(start)
+	s.limit = uint32(len(line))
+	s.line++
+
+	if !s.keepnls && tail > 0 {
+		line = line[:len(line)-1]
+		if len(line) > 0 && line[len(line)-1] == CR {
+			line = line[:len(line)-1]
+		}
+	}
+	s.token = line
+
+	return true
+}
+
+// BOM returns the byte order mark found at the beginning of the file (nil when there is none).
+// It is only known after the first call to ScanLine.
+func (s *ReverseScanner) BOM() []byte {
+	return s.bom
+}
+
+// Reset moves to the end of file and cleans buffer.
+func (s *ReverseScanner) Reset() {
+	s.size = -1
+	s.window = nil
+	s.token = nil
+	s.err = nil
+	s.line = 0
+	s.offset = 0
+	s.limit = 0
+}
+
+// init fetches the size of the file.
+func (s *ReverseScanner) init() error {
+	size, err := s.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	s.size = size
+	s.wstart = size
+	s.bom = readBOM(s.f)
+	return nil
+}
+
+// start returns the offset of the first line (after the byte order mark).
+func (s *ReverseScanner) start() int64 {
+	return int64(len(s.bom))
+}
+
+// readBlock reads the block that precedes the window.
+func (s *ReverseScanner) readBlock() error {
+	n := int64(s.BlockSize)
+	if n <= 0 {
+		n = DefaultReverseBlockSize
+	}
+	if l := int64(len(s.window)); l > n {
+		n = l // Grows quickly on very long lines
+	}
+	if n > s.wstart-s.start() {
+		n = s.wstart - s.start()
+	}
+
+	window := make([]byte, int(n)+len(s.window))
+	if _, err := s.f.ReadAt(window[:n], s.wstart-n); err != nil && err != io.EOF {
+		return err
+	}
+	copy(window[n:], s.window)
+
+	s.window = window
+	s.wstart -= n
+	return nil
+}
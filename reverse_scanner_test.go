@@ -0,0 +1,194 @@
+package iosupport_test
+
+import (
+	"strings"
+
+	. "github.com/mdouchement/iosupport"
+	"github.com/mdouchement/stringio"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReverseScanner", func() {
+	Describe("#ScanLine", func() {
+		Context("with a normal file", func() {
+			var file = stringio.NewFromString("The first line.\nThe second line :)\n\n")
+			var subject = NewReverseScanner(file)
+			var actual = []string{}
+			var offsets = []uint64{}
+			var limits = []uint32{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+				offsets = append(offsets, subject.Offset())
+				limits = append(limits, subject.Limit())
+			}
+
+			It("reads the file backward", func() {
+				Expect(actual).To(Equal([]string{"", "The second line :)", "The first line."}))
+			})
+
+			It("computes the offsets", func() {
+				Expect(offsets).To(Uint64ConsistOf(35, 16, 0))
+			})
+
+			It("computes the limits", func() {
+				Expect(limits).To(Uint32ConsistOf(1, 19, 16))
+			})
+		})
+
+		Context("when the file does not end with a newline", func() {
+			var file = stringio.NewFromString("The first line.\nThe second line :)")
+			var subject = NewReverseScanner(file)
+			var actual = []string{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+			}
+
+			It("reads the file backward", func() {
+				Expect(actual).To(Equal([]string{"The second line :)", "The first line."}))
+			})
+		})
+
+		Context("when the file uses CRLF", func() {
+			var file = stringio.NewFromString("The first line.\r\nThe second line.\r\n\r\n")
+			var subject = NewReverseScanner(file)
+			var actual = []string{}
+			var offsets = []uint64{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+				offsets = append(offsets, subject.Offset())
+			}
+
+			It("reads the file backward", func() {
+				Expect(actual).To(Equal([]string{"", "The second line.", "The first line."}))
+			})
+
+			It("computes the offsets", func() {
+				Expect(offsets).To(Uint64ConsistOf(35, 17, 0))
+			})
+		})
+
+		Context("when lines are longer than a block", func() {
+			var long = strings.Repeat("a", 100)
+			var file = stringio.NewFromString("b\n" + long + "\r\nc\n")
+			var subject = NewReverseScanner(file)
+			subject.BlockSize = 3
+			var actual = []string{}
+			var offsets = []uint64{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+				offsets = append(offsets, subject.Offset())
+			}
+
+			It("reads the file backward", func() {
+				Expect(actual).To(Equal([]string{"c", long, "b"}))
+			})
+
+			It("computes the offsets", func() {
+				Expect(offsets).To(Uint64ConsistOf(104, 2, 0))
+			})
+		})
+
+		Context("when the block size is not positive", func() {
+			var subject = NewReverseScanner(stringio.NewFromString("a\nb\n"))
+			subject.BlockSize = 0
+			var actual = []string{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+			}
+
+			It("reads the file backward with the default block size", func() {
+				Expect(actual).To(Equal([]string{"b", "a"}))
+			})
+		})
+
+		Context("when the file starts with a byte order mark", func() {
+			var data = "\xEF\xBB\xBFa\nb\n"
+			var subject = NewReverseScanner(stringio.NewFromString(data))
+			subject.BlockSize = 2
+			var actual = []string{}
+			var offsets = []uint64{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+				offsets = append(offsets, subject.Offset())
+			}
+
+			It("skips it like the Scanner", func() {
+				Expect(actual).To(Equal([]string{"b", "a"}))
+				Expect(subject.BOM()).To(Equal(UTF8BOM))
+
+				sc := NewScanner(stringio.NewFromString(data))
+				sc.ScanLine()
+				Expect(offsets[1]).To(Equal(sc.Offset()))
+			})
+		})
+
+		Context("when the file is empty", func() {
+			var subject = NewReverseScanner(stringio.NewFromString(""))
+
+			It("does not read any line", func() {
+				Expect(subject.ScanLine()).To(BeFalse())
+				Expect(subject.Err()).To(BeNil())
+			})
+		})
+	})
+
+	Describe("#KeepNewlineSequence", func() {
+		var file = stringio.NewFromString("The first line.\r\nThe second line :)\n\n")
+		var subject = NewReverseScanner(file)
+		subject.KeepNewlineSequence(true)
+		var actual = []string{}
+
+		for subject.ScanLine() {
+			actual = append(actual, subject.Text())
+		}
+
+		It("keeps newline sequence in read lines", func() {
+			Expect(actual).To(Equal([]string{"\n", "The second line :)\n", "The first line.\r\n"}))
+		})
+	})
+
+	Describe("#Line", func() {
+		var file = stringio.NewFromString("a\nb\nc\n")
+		var subject = NewReverseScanner(file)
+		var actual = []int{}
+
+		for subject.ScanLine() {
+			actual = append(actual, subject.Line())
+		}
+
+		It("counts the lines from the end", func() {
+			Expect(actual).To(Equal([]int{1, 2, 3}))
+		})
+	})
+
+	Describe("#Reset", func() {
+		var file = stringio.NewFromString("line1.\nline2.\n")
+		var subject = NewReverseScanner(file)
+		var actual = []string{}
+
+		subject.ScanLine()
+		actual = append(actual, subject.Text())
+		subject.Reset()
+		for subject.ScanLine() {
+			actual = append(actual, subject.Text())
+		}
+
+		It("allows to re-read the file", func() {
+			Expect(actual).To(Equal([]string{"line2.", "line2.", "line1."}))
+		})
+	})
+})
@@ -0,0 +1,259 @@
+package iosupport
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This RowDecoder decodes the rows of a TsvParser into structs.
+// The columns are mapped to the struct fields by the names found in the header row:
+//
+// type Person struct {
+//   Name      string    `tsv:"name"`
+//   Age       int       `tsv:"age"`
+//   Birthdate time.Time `tsv:"birthdate,layout=2006-01-02"`
+//   Ignored   string    `tsv:"-"`
+// }
+//
+// parser := iosupport.NewTsvParser(sc, ',')
+// decoder, _ := iosupport.NewRowDecoder(parser) // Reads the header row
+// for parser.ScanRow() {
+//   var person Person
+//   if err := decoder.Decode(parser.Row(), &person); err != nil {
+//     panic(err)
+//   }
+// }
+//
+// The untagged exported fields are mapped with their name. The supported types are strings, []byte, ints, uints,
+// floats, bools, time.Time (RFC 3339 unless a layout is given), encoding.TextUnmarshaler and pointers to these types.
+// An empty or NULL field leaves the zero value (nil for pointers).
+
+// ErrInvalidDecodeTarget -> the decoded value is not a pointer to a struct
+var ErrInvalidDecodeTarget = errors.New("decoded value must be a non-nil pointer to a struct")
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	timeType            = reflect.TypeOf(time.Time{}) // Decoded with its layout instead of UnmarshalText
+)
+
+type (
+	// A DecodeError is returned when a field can not be converted.
+	// The first line is 1. The first column is 0.
+	DecodeError struct {
+		Line   int    // Line of the row
+		Column int    // Column (field index) where the error occurred
+		Field  string // Name of the column
+		Err    error  // The actual error
+	}
+
+	// A RowDecoder decodes rows into structs according to a header.
+	RowDecoder struct {
+		parser *TsvParser
+		header map[string]int
+		fields map[reflect.Type][]structField // Cache of the mapped fields
+	}
+
+	structField struct {
+		index  []int  // Index of the field in the struct
+		column int    // Index of the column in the row
+		name   string // Name of the column
+		layout string // Time layout
+	}
+)
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("line %d, column %d (%s): %s", e.Line, e.Column, e.Field, e.Err)
+}
+
+// NewRowDecoder instanciates a new RowDecoder, the header row is read from the given parser.
+// With TsvParser.HasHeader, the header of the parser is used.
+func NewRowDecoder(tp *TsvParser) (*RowDecoder, error) {
+	var ok bool
+	if tp.HasHeader {
+		ok = tp.scanHeader()
+	} else {
+		ok = tp.ScanRow()
+	}
+	if tp.Err() != nil {
+		return nil, tp.Err()
+	}
+	if !ok {
+		return nil, errors.New("RowDecoder: no header row")
+	}
+
+	var d *RowDecoder
+	if tp.HasHeader {
+		d = NewRowDecoderWithHeader(nil)
+		d.header = tp.columns
+	} else {
+		d = NewRowDecoderWithHeader(tp.Row())
+	}
+	d.parser = tp
+	return d, nil
+}
+
+// NewRowDecoderWithHeader instanciates a new RowDecoder with the given header row.
+func NewRowDecoderWithHeader(header [][]byte) *RowDecoder {
+	d := &RowDecoder{
+		header: make(map[string]int, len(header)),
+		fields: make(map[reflect.Type][]structField),
+	}
+	for i, name := range header {
+		d.header[string(name)] = i
+	}
+	return d
+}
+
+// Decode converts the given row into v which must be a pointer to a struct.
+// The line of the DecodeError is the current line of the parser given to NewRowDecoder.
+func (d *RowDecoder) Decode(row [][]byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrInvalidDecodeTarget
+	}
+	rv = rv.Elem()
+
+	for _, field := range d.structFields(rv.Type()) {
+		if field.column >= len(row) {
+			continue
+		}
+		if err := decodeField(rv.FieldByIndex(field.index), row[field.column], field.layout); err != nil {
+			return d.error(field, err)
+		}
+	}
+	return nil
+}
+
+// error creates a new DecodeError based on err.
+func (d *RowDecoder) error(field structField, err error) error {
+	line := 0
+	if d.parser != nil {
+		line = d.parser.Line()
+	}
+	return &DecodeError{
+		Line:   line,
+		Column: field.column,
+		Field:  field.name,
+		Err:    err,
+	}
+}
+
+// structFields returns the fields of the given struct type that are present in the header.
+func (d *RowDecoder) structFields(t reflect.Type) []structField {
+	if fields, ok := d.fields[t]; ok {
+		return fields
+	}
+
+	fields := []structField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+
+		name, layout := f.Name, time.RFC3339
+		if tag, ok := f.Tag.Lookup("tsv"); ok {
+			if tag == "-" {
+				continue
+			}
+			options := strings.Split(tag, ",")
+			if options[0] != "" {
+				name = options[0]
+			}
+			for _, option := range options[1:] {
+				if strings.HasPrefix(option, "layout=") {
+					layout = strings.TrimPrefix(option, "layout=")
+				}
+			}
+		}
+
+		if column, ok := d.header[name]; ok {
+			fields = append(fields, structField{index: f.Index, column: column, name: name, layout: layout})
+		}
+	}
+
+	d.fields[t] = fields
+	return fields
+}
+
+// decodeField converts the given field and sets it to v.
+func decodeField(v reflect.Value, field []byte, layout string) error {
+	if v.Kind() == reflect.Ptr {
+		if len(field) == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) && v.Type() != timeType {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(field)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(string(field))
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if field != nil {
+				field = append([]byte{}, field...) // The row is reused by the parser
+			}
+			v.SetBytes(field)
+			return nil
+		}
+	}
+
+	if len(field) == 0 {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	s := string(field)
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Struct:
+		if v.Type() != timeType {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package iosupport_test
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/MakeNowJust/heredoc"
+	. "github.com/mdouchement/iosupport"
+	"github.com/mdouchement/stringio"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type level int
+
+func (l *level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = 1
+	case "high":
+		*l = 2
+	default:
+		return errors.New("unknown level")
+	}
+	return nil
+}
+
+type person struct {
+	Name      string    `tsv:"name"`
+	Age       int8      `tsv:"age"`
+	Score     float64   `tsv:"score"`
+	Active    bool      `tsv:"active"`
+	Birthdate time.Time `tsv:"birthdate,layout=2006-01-02"`
+	Level     level     `tsv:"level"`
+	Nickname  *string   `tsv:"nickname"`
+	City      string
+	Ignored   string `tsv:"-"`
+	Missing   string `tsv:"missing"`
+}
+
+var _ = Describe("RowDecoder", func() {
+	var decode = func(data string) ([]person, error) {
+		var subject = NewTsvParser(NewScanner(stringio.NewFromString(data)), ',')
+		decoder, err := NewRowDecoder(subject)
+		if err != nil {
+			return nil, err
+		}
+
+		var people = []person{}
+		for subject.ScanRow() {
+			check(subject.Err())
+			var p person
+			if err := decoder.Decode(subject.Row(), &p); err != nil {
+				return people, err
+			}
+			people = append(people, p)
+		}
+		return people, subject.Err()
+	}
+
+	Describe("#Decode", func() {
+		Context("with a well formatted TSV", func() {
+			var actual, err = decode(heredoc.Doc(`level,City,name,age,score,active,birthdate,nickname,Ignored
+				low,Paris,Alice,42,1.5,true,1975-04-12,Al,x
+				high,Lyon,Bob,7,-3,false,2010-11-02,,x`))
+			check(err)
+			var nickname = "Al"
+
+			It("maps the header names to the struct fields", func() {
+				Expect(actual).To(Equal([]person{
+					{
+						Name:      "Alice",
+						Age:       42,
+						Score:     1.5,
+						Active:    true,
+						Birthdate: time.Date(1975, 4, 12, 0, 0, 0, 0, time.UTC),
+						Level:     1,
+						Nickname:  &nickname,
+						City:      "Paris",
+					},
+					{
+						Name:      "Bob",
+						Age:       7,
+						Score:     -3,
+						Birthdate: time.Date(2010, 11, 2, 0, 0, 0, 0, time.UTC),
+						Level:     2,
+						City:      "Lyon",
+					},
+				}))
+			})
+		})
+
+		Context("when a field cannot be converted", func() {
+			var _, err = decode(heredoc.Doc(`name,age
+				Alice,42
+				Bob,300`))
+
+			It("returns a DecodeError with the line and the column", func() {
+				Expect(err).To(HaveOccurred())
+				decodeErr, ok := err.(*DecodeError)
+				Expect(ok).To(BeTrue())
+				Expect(decodeErr.Line).To(Equal(3))
+				Expect(decodeErr.Column).To(Equal(1))
+				Expect(decodeErr.Field).To(Equal("age"))
+				Expect(err.Error()).To(HavePrefix("line 3, column 1 (age): "))
+			})
+		})
+
+		Context("when a TextUnmarshaler fails", func() {
+			var _, err = decode(heredoc.Doc(`name,level
+				Alice,medium`))
+
+			It("returns a DecodeError", func() {
+				Expect(err).To(MatchError("line 2, column 1 (level): unknown level"))
+			})
+		})
+
+		Context("when the value is not a pointer to a struct", func() {
+			var decoder = NewRowDecoderWithHeader([][]byte{[]byte("name")})
+			var p person
+
+			It("returns an error", func() {
+				Expect(decoder.Decode([][]byte{[]byte("Alice")}, p)).To(Equal(ErrInvalidDecodeTarget))
+				Expect(decoder.Decode([][]byte{[]byte("Alice")}, &p)).To(Succeed())
+				Expect(p.Name).To(Equal("Alice"))
+			})
+		})
+	})
+
+	Describe("NewRowDecoder", func() {
+		Context("when the parser reads the header", func() {
+			var subject = NewTsvParser(NewScanner(stringio.NewFromString("name,age\nAlice,42")), ',')
+			subject.HasHeader = true
+			var decoder, err = NewRowDecoder(subject)
+			check(err)
+
+			It("uses the header of the parser", func() {
+				var p person
+				Expect(subject.ScanRow()).To(BeTrue())
+				Expect(decoder.Decode(subject.Row(), &p)).To(Succeed())
+				Expect(p.Name).To(Equal("Alice"))
+				Expect(p.Age).To(BeEquivalentTo(42))
+			})
+		})
+
+		Context("with an empty TSV", func() {
+			var _, err = decode("")
+
+			It("returns an error", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(strings.Contains(err.Error(), "header")).To(BeTrue())
+			})
+		})
+	})
+})
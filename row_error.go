@@ -0,0 +1,112 @@
+package iosupport
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// An Action is returned by the error policy of the TsvIndexer (see OnRowError option).
+type Action int
+
+const (
+	// AbortRow stops Analyze with the RowError.
+	AbortRow Action = iota
+	// SkipRow drops the row, it is written to the reject file (see RejectFile option).
+	SkipRow
+	// KeepRow indexes the row anyway, the missing key columns are empty.
+	// A row with a parse error is read again like with LazyQuotes, so the fields that follow the error are indexed.
+	KeepRow
+)
+
+var (
+	// ErrFieldCount -> the row has not the same number of fields as the first row
+	ErrFieldCount = errors.New("wrong number of fields")
+	// ErrMissingKeyColumn -> a column used as sort field is missing in the row
+	ErrMissingKeyColumn = errors.New("missing key column")
+)
+
+// A RowError describes a malformed row found by Analyze.
+// The first line is 1. The first column is 0.
+type RowError struct {
+	Line   int    // Line of the row
+	Offset uint64 // Offset of the row
+	Column int    // Character index for parse errors, field index otherwise
+	Raw    []byte // The row as read (without its newline sequence)
+	Err    error  // ErrBareQuote, ErrQuote, ErrFieldCount or ErrMissingKeyColumn
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Err)
+}
+
+// Is makes errors.Is(err, ErrFieldCount) and the like work.
+func (e *RowError) Is(target error) bool {
+	return target == e.Err
+}
+
+// handleParseError applies the error policy on a parse error. It returns whether the row is indexed.
+func (ti *TsvIndexer) handleParseError(err error, nrow int) (bool, error) {
+	perr, ok := err.(*ParseError)
+	tp, isParser := ti.rows.(*TsvParser)
+	if !ok || !isParser || (nrow == 0 && ti.Header) || ti.OnRowError == nil {
+		return false, err // Fatal error, error in the header or default policy
+	}
+	tp.err = nil // The error only concerns the current row
+
+	ok, err = ti.handleRowError(perr.Column, perr.Err, AbortRow) // The fallback is not used
+	if ok {
+		// The row stops at the error, it is read again without the strict quoting rules
+		lazy := tp.LazyQuotes
+		tp.LazyQuotes = true
+		tp.row = tp.parseFields()
+		tp.LazyQuotes = lazy
+		tp.err = nil
+	}
+	return ok, err
+}
+
+// handleRowError applies the error policy on the current row. It returns whether the row is indexed.
+func (ti *TsvIndexer) handleRowError(column int, cause error, fallback Action) (bool, error) {
+	rerr := &RowError{
+		Line:   ti.rows.Line(),
+		Offset: ti.rows.Offset(),
+		Column: column,
+		Raw:    append([]byte{}, ti.parser.trimDelimiter(ti.parser.Bytes())...),
+		Err:    cause,
+	}
+
+	action := fallback
+	if ti.OnRowError != nil {
+		action = ti.OnRowError(rerr)
+	}
+
+	switch action {
+	case KeepRow:
+		return true, nil
+	case SkipRow:
+		ti.Rejects[cause]++
+		return false, ti.rejects.WriteRow([][]byte{
+			[]byte(strconv.Itoa(rerr.Line)),
+			[]byte(strconv.FormatUint(rerr.Offset, 10)),
+			[]byte(rerr.Error()),
+			rerr.Raw,
+		})
+	default:
+		return false, rerr
+	}
+}
+
+// newRejectWriter returns the TsvWriter of the reject file (it writes nothing without reject file).
+func newRejectWriter(w FileWriter) *TsvWriter {
+	if w == nil {
+		return NewTsvWriter(nopWriter{}, '\t')
+	}
+	return NewTsvWriter(w, '\t')
+}
+
+type nopWriter struct{}
+
+func (nopWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
@@ -0,0 +1,97 @@
+package iosupport_test
+
+import (
+	"errors"
+
+	. "github.com/mdouchement/iosupport"
+	"github.com/mdouchement/stringio"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RowError", func() {
+	var data = "c1,c2,c3\nb,2,x\nc\"c,3,y\nd,4\na,1,z,extra\n"
+
+	Context("with the default policy", func() {
+		It("aborts on parse errors", func() {
+			var subject = NewTsvIndexer(scanner(data), HasHeader(), Fields("c1"))
+			var err = subject.Analyze()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("line 3, character 2: " + ErrBareQuote.Error()))
+		})
+
+		It("aborts on missing key columns", func() {
+			var subject = NewTsvIndexer(scanner("c1,c2,c3\nb,2,x\nd,4\n"), HasHeader(), Fields("c3"))
+			var err = subject.Analyze()
+			Expect(errors.Is(err, ErrMissingKeyColumn)).To(BeTrue())
+			Expect(err.Error()).To(Equal("line 3, column 2: missing key column"))
+		})
+	})
+
+	Context("when the rows are skipped", func() {
+		var rejects = stringio.New()
+		var rowErrors = []*RowError{}
+		var subject = NewTsvIndexer(scanner(data), HasHeader(), Fields("c3"), RejectFile(rejects),
+			OnRowError(func(rerr *RowError) Action {
+				rowErrors = append(rowErrors, rerr)
+				return SkipRow
+			}))
+
+		err := subject.Analyze()
+		check(err)
+
+		It("indexes the valid rows", func() {
+			Expect(subject.Lines).To(TlConsistOf(tl{"", 0, 9}, tl{cs("x"), 9, 6}))
+		})
+
+		It("gives the details of the errors", func() {
+			Expect(rowErrors).To(HaveLen(3))
+			Expect(*rowErrors[0]).To(Equal(RowError{Line: 3, Offset: 15, Column: 2, Raw: []byte("c\"c,3,y"), Err: ErrBareQuote}))
+			Expect(*rowErrors[1]).To(Equal(RowError{Line: 4, Offset: 23, Column: 2, Raw: []byte("d,4"), Err: ErrFieldCount}))
+			Expect(*rowErrors[2]).To(Equal(RowError{Line: 5, Offset: 27, Column: 3, Raw: []byte("a,1,z,extra"), Err: ErrFieldCount}))
+		})
+
+		It("counts the rejects", func() {
+			Expect(subject.Rejects).To(Equal(map[error]int{ErrBareQuote: 1, ErrFieldCount: 2}))
+		})
+
+		It("writes the rejected rows", func() {
+			Expect(rejects.GetValueString()).To(Equal(
+				"3\t15\t\"line 3, column 2: bare \"\" in non-quoted-field\"\t\"c\"\"c,3,y\"\n" +
+					"4\t23\tline 4, column 2: wrong number of fields\td,4\n" +
+					"5\t27\tline 5, column 3: wrong number of fields\ta,1,z,extra\n"))
+		})
+	})
+
+	Context("when the rows are kept", func() {
+		var subject = NewTsvIndexer(scanner(data), HasHeader(), Fields("c3"), OnRowError(func(*RowError) Action {
+			return KeepRow
+		}))
+
+		err := subject.Analyze()
+		check(err)
+
+		It("indexes all the rows", func() {
+			Expect(subject.Lines).To(TlConsistOf(
+				tl{"", 0, 9},
+				tl{cs("x"), 9, 6},
+				tl{cs("y"), 15, 8},
+				tl{cs(""), 23, 4},
+				tl{cs("z"), 27, 12},
+			))
+		})
+	})
+
+	Context("with SkipMalformattedLines", func() {
+		var subject = NewTsvIndexer(scanner("c1,c2\nb,2\nd\na,1\n"), HasHeader(), Fields("c1"), SkipMalformattedLines())
+
+		err := subject.Analyze()
+		check(err)
+
+		It("counts the skipped rows", func() {
+			Expect(subject.Lines).To(TlConsistOf(tl{"", 0, 6}, tl{cs("b"), 6, 4}, tl{cs("a"), 12, 4}))
+			Expect(subject.Rejects).To(Equal(map[error]int{ErrFieldCount: 1}))
+		})
+	})
+})
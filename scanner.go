@@ -2,7 +2,15 @@ package iosupport
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
 )
 
 // This Scanner provides very large file reader (also file with very long lines).
@@ -25,6 +33,29 @@ import (
 // })
 //
 // See other methods for custom usage.
+//
+// A byte order mark (BOM) at the beginning of the file is detected and skipped,
+// UTF-16 files with a BOM are transcoded to UTF-8. Other encodings can be given with SetEncoding.
+// Offset and Limit always refer to the bytes of the file (not the transcoded ones).
+//
+// A line longer than the maximum line length (see MaxLineLength) makes ScanLine fail with a LineTooLongError,
+// the line is not buffered. It can be skipped (see SkipLongLines) or read by pieces with WriteLineTo:
+//
+// for {
+//   for sc.ScanLine() {
+//     println(sc.Text())
+//   }
+//   if !errors.Is(sc.Err(), iosupport.ErrLineTooLong) {
+//     break
+//   }
+//   sc.WriteLineTo(hash)
+// }
+//
+// By default, the lines are terminated by any newline sequence (LF, CR, CRLF or LFCR).
+// SetDelimiter defines a stricter policy (e.g. only LF, only CRLF, NUL like `find -print0') or any sequence of bytes:
+//
+// sc := supports.NewScanner(file)
+// sc.SetDelimiter(iosupport.NULDelimiter)
 
 var (
 	// LF -> linefeed
@@ -32,19 +63,69 @@ var (
 	// CR -> carriage return
 	CR       byte = '\r'
 	newLines      = []byte{CR, LF}
+
+	// ErrLineTooLong -> line longer than the maximum line length
+	ErrLineTooLong = errors.New("line too long")
+
+	// AnyNewline terminates the lines by LF, CR, CRLF or LFCR.
+	AnyNewline Delimiter
+	// LFDelimiter terminates the lines only by LF, a CR is a part of the line.
+	LFDelimiter = Delimiter{LF}
+	// CRLFDelimiter terminates the lines only by CRLF, a lone CR or LF is a part of the line.
+	CRLFDelimiter = Delimiter{CR, LF}
+	// NULDelimiter terminates the lines by a null byte.
+	NULDelimiter = Delimiter{0}
 )
 
+// A Delimiter is the sequence of bytes that terminates a line, nil means any newline sequence.
+type Delimiter []byte
+
+// DefaultMaxLineLength is the default maximum length of a line including its newline sequence.
+// It is the greatest length that fits in a Limit.
+const DefaultMaxLineLength = math.MaxUint32
+
+// A LineTooLongError is returned when a line is longer than the maximum line length of the Scanner.
+type LineTooLongError struct {
+	Line   int    // Index of the line
+	Offset uint64 // Offset of the start of the line
+}
+
+func (e *LineTooLongError) Error() string {
+	return fmt.Sprintf("line %d, offset %d: %s", e.Line, e.Offset, ErrLineTooLong)
+}
+
+// Is makes errors.Is(err, ErrLineTooLong) true.
+func (e *LineTooLongError) Is(target error) bool {
+	return target == ErrLineTooLong
+}
+
 // Scanner contains all stuff for reading a buffered file.
 type Scanner struct {
 	f               FileReader    // The file provided by the client.
 	r               *bufio.Reader // Buffered reader on given file.
 	keepnls         bool          // Keep the newline sequence in returned strings
+	nocopy          bool          // Return lines that reference the read buffer when possible
 	newlineSequence []byte        // Backup line terminators sequence (e.g. \r\n)
+	delim           Delimiter     // Line terminator, nil means any newline sequence (see SetDelimiter).
+	buf             []byte        // Reused buffer that holds the token.
 	token           []byte        // Last token returned by split (scan).
+	borrowed        bool          // The token references the read buffer (see nocopy).
 	err             error         // Sticky error.
 	line            int           // index of current read line.
 	offset          uint64        // Offset of the start of the read line.
-	limit           uint32        // Length of the read line including newline sequence.
+	limit           uint64        // Length of the read line including newline sequence.
+	start           uint64        // Offset from where the lines are read (see SeekOffset).
+	end             uint64        // Offset where the scan stops (see StopAt), 0 means EOF.
+	maxlen          uint64        // Maximum length of a line (see MaxLineLength).
+	skiplong        bool          // Skip the lines longer than maxlen.
+
+	// Encoding stuff
+	detected bool                  // The BOM detection has been done.
+	bom      []byte                // Byte order mark found at the beginning of the file.
+	enc      encoding.Encoding     // Encoding of the file (nil for UTF-8).
+	encoder  transform.Transformer // Used to compute the length of the lines in the file.
+	encbuf   []byte                // Buffer used by the encoder.
+	nlLen    int                   // Length of an encoded newline character.
 }
 
 // NewScanner instanciates a Scanner
@@ -54,6 +135,8 @@ func NewScanner(f FileReader) *Scanner {
 		r:       bufio.NewReader(f),
 		keepnls: false,
 		line:    0,
+		nlLen:   1,
+		maxlen:  DefaultMaxLineLength,
 	}
 }
 
@@ -62,13 +145,62 @@ func (s *Scanner) KeepNewlineSequence(b bool) {
 	s.keepnls = b
 }
 
+// NoCopy makes Bytes returns a slice of the internal read buffer when the whole line is buffered.
+// It avoids a copy but the returned bytes are only valid until the next call to ScanLine.
+func (s *Scanner) NoCopy(b bool) {
+	s.nocopy = b
+}
+
+// MaxLineLength defines the maximum length of a line including its newline sequence (in bytes of the read line).
+// Zero or a length greater than DefaultMaxLineLength restores DefaultMaxLineLength.
+func (s *Scanner) MaxLineLength(n int) {
+	s.maxlen = uint64(n)
+	if n <= 0 || s.maxlen > DefaultMaxLineLength {
+		s.maxlen = DefaultMaxLineLength
+	}
+}
+
+// SkipLongLines skips the lines longer than the maximum line length instead of failing with a LineTooLongError.
+// The skipped lines are counted by Line.
+func (s *Scanner) SkipLongLines(b bool) {
+	s.skiplong = b
+}
+
+// SetDelimiter defines the sequence of bytes that terminates a line (AnyNewline by default).
+// The delimiter is given in UTF-8 when the file is transcoded. An empty delimiter means AnyNewline.
+func (s *Scanner) SetDelimiter(delim Delimiter) {
+	s.delim = nil
+	if len(delim) > 0 {
+		s.delim = delim
+	}
+}
+
+// SetEncoding transcodes the file from the given encoding to UTF-8 and resets the Scanner.
+// A nil encoding disables the transcoding.
+func (s *Scanner) SetEncoding(enc encoding.Encoding) {
+	s.useEncoding(enc)
+	s.Reset()
+}
+
+// BOM returns the byte order mark found at the beginning of the file.
+func (s *Scanner) BOM() []byte {
+	if !s.detected {
+		s.detectBOM()
+	}
+	return s.bom
+}
+
 // NewlineSequence returns the found line terminators sequence in the file when newlines are keeped
+// or the delimiter defined by SetDelimiter.
 func (s *Scanner) NewlineSequence() []byte {
+	if s.delim != nil {
+		return s.delim
+	}
 	return s.newlineSequence
 }
 
 // Bytes returns the most recent line generated by a call to Scan.
-// The underlying array may point to data that will be overwritten
+// The underlying array points to data that will be overwritten
 // by a subsequent call to Scan. It does no allocation.
 func (s *Scanner) Bytes() []byte {
 	return s.token
@@ -100,7 +232,7 @@ func (s *Scanner) Offset() uint64 {
 
 // Limit return the byte length of the current line including newline sequence.
 func (s *Scanner) Limit() uint32 {
-	return s.limit
+	return uint32(s.limit)
 }
 
 // ScanLine advances the Scanner to the next line), which will then be
@@ -110,42 +242,146 @@ func (s *Scanner) Limit() uint32 {
 // occurred during scanning, except that if it was io.EOF, Err
 // will return nil.
 func (s *Scanner) ScanLine() bool {
-	// Override token value to new bytes array
-	s.token = make([]byte, 0)
-	s.offset += uint64(s.limit)
-	s.line++
+	if !s.init() {
+		return false
+	}
+	return s.scanLine()
+}
+
+func (s *Scanner) scanLine() bool {
+	if !s.nextLine() {
+		return false
+	}
+	if s.delim != nil {
+		return s.scanDelimited()
+	}
 
 	// Loop until we have a token.
 	for {
-		b, err := s.r.ReadByte()
+		chunk, err := s.peek()
 
 		// End-of-file detection or error detection
-		if err != nil {
+		if len(chunk) == 0 {
+			s.err = err
 			if err == io.EOF {
-				s.limit = uint32(len(s.token))
+				s.limit = uint64(len(s.token))
+				return !s.IsLineEmpty() && s.checkLine()
 			}
-			s.err = err
 			return !s.IsLineEmpty()
 		}
 
-		switch b {
-		case LF:
+		i := indexNewline(chunk)
+		if i < 0 {
+			if uint64(len(s.token)+len(chunk)) > s.maxlen {
+				return s.tooLong(false)
+			}
+			// The line continues after the buffered data
+			s.appendToken(chunk)
+			s.r.Discard(len(chunk))
+			continue
+		}
+
+		if uint64(len(s.token)+i) > s.maxlen {
+			return s.tooLong(false)
+		}
+		if s.nocopy && len(s.token) == 0 {
+			s.token = chunk[:i]
+			s.borrowed = true
+		} else {
+			s.appendToken(chunk[:i])
+		}
+		s.r.Discard(i + 1)
+
+		if chunk[i] == LF {
 			s.handleNewLineSequence(LF, CR)
-			return true
-		case CR:
+		} else {
 			s.handleNewLineSequence(CR, LF)
-			return true
-		default:
-			s.token = append(s.token, b)
 		}
+		return s.checkLine()
 	}
 }
 
+// scanDelimited reads the line terminated by the delimiter defined by SetDelimiter.
+func (s *Scanner) scanDelimited() bool {
+	for {
+		chunk, err := s.peekDelimited()
+
+		// End-of-file detection or error detection
+		if len(chunk) == 0 {
+			s.err = err
+			if err == io.EOF {
+				s.limit = uint64(len(s.token))
+				return !s.IsLineEmpty() && s.checkLine()
+			}
+			return !s.IsLineEmpty()
+		}
+
+		i := bytes.Index(chunk, s.delim)
+		if i < 0 {
+			// The line continues after the buffered data
+			n := safeLen(chunk, s.delim, err)
+			if uint64(len(s.token)+n) > s.maxlen {
+				return s.tooLong(false)
+			}
+			s.appendToken(chunk[:n])
+			s.r.Discard(n)
+			continue
+		}
+
+		if uint64(len(s.token)+i) > s.maxlen {
+			return s.tooLong(false)
+		}
+		if s.nocopy && len(s.token) == 0 {
+			s.token = chunk[:i]
+			s.borrowed = true
+		} else {
+			s.appendToken(chunk[:i])
+		}
+		s.r.Discard(i + len(s.delim))
+		s.limit = uint64(len(s.token) + len(s.delim))
+
+		if s.keepnls {
+			if s.borrowed {
+				// The delimiter follows the token in the read buffer
+				s.token = s.token[:len(s.token)+len(s.delim)]
+			} else {
+				s.buf = append(s.token, s.delim...)
+				s.token = s.buf
+			}
+		}
+		return s.checkLine()
+	}
+}
+
+// WriteLineTo advances the Scanner to the next line and writes it into w by pieces, the line is never entirely buffered.
+// It is not bound by the maximum line length, so it allows to read the line that has made ScanLine fail.
+// It returns the number of written bytes. At the end of the input, the error is io.EOF.
+// Offset refers to the written line but Limit is not reliable for lines longer than DefaultMaxLineLength.
+func (s *Scanner) WriteLineTo(w io.Writer) (int64, error) {
+	if !s.init() {
+		return 0, s.err
+	}
+	if !s.nextLine() {
+		return 0, s.err
+	}
+
+	n, limit, err := s.streamLine(w, s.keepnls)
+	s.limit = limit
+	if err != nil {
+		s.err = err
+		if err == io.EOF && limit > 0 {
+			err = nil // Last line without newline sequence
+		}
+	}
+	return n, err
+}
+
 // EachLine iterate on each line and execute the given function.
+// The given line is a copy that can be retained by the function.
 func (s *Scanner) EachLine(fn func([]byte, error)) {
 	s.Reset()
 	for s.ScanLine() {
-		fn(s.Bytes(), s.Err())
+		fn(append([]byte{}, s.Bytes()...), s.Err())
 	}
 }
 
@@ -181,50 +417,341 @@ func (s *Scanner) IsLineEmpty() bool {
 	return len(s.token) == 0
 }
 
-// Reset seek to top of file and clean buffer.
+// Reset seek to top of file (or to the offset given to SeekOffset) and clean buffer.
 func (s *Scanner) Reset() {
-	s.f.Seek(0, 0)
+	if err := s.rewind(); err != nil {
+		s.setErr(err)
+	}
+}
+
+// SeekOffset moves the Scanner to the first line that starts at or after the given offset.
+// The line that contains the offset is skipped when the offset is not the beginning of a line.
+// After a SeekOffset, Line returns the index of the read line relative to the given offset
+// and Reset rewinds the Scanner to the given offset.
+//
+// Combined with StopAt, a file can be split in several byte ranges where each line is read by only one Scanner:
+//
+//	sc1 := iosupport.NewScanner(file1)
+//	sc1.StopAt(1 << 30)
+//	sc2 := iosupport.NewScanner(file2)
+//	sc2.SeekOffset(1 << 30)
+func (s *Scanner) SeekOffset(offset uint64) error {
+	s.start = offset
+	return s.rewind()
+}
+
+// StopAt bounds the Scanner, no line starting at or after the given offset is read.
+// A line that starts before the offset is entirely read. Zero means no bound.
+func (s *Scanner) StopAt(offset uint64) {
+	s.end = offset
+}
+
+// rewind moves the Scanner to its start offset.
+func (s *Scanner) rewind() error {
+	if !s.detected {
+		s.detectBOM()
+	}
+
+	offset := uint64(len(s.bom))
+	skip := s.start > offset
+	if skip && s.start-offset > uint64(s.delimLen()) {
+		// The previous delimiter tells whether start is the beginning of a line
+		offset = s.start - uint64(s.delimLen())
+	}
+
+	if err := s.seek(offset); err != nil {
+		return err
+	}
 	s.line = 0
 	s.limit = 0
-	s.offset = 0
-	s.token = []byte{}
+	s.offset = offset
+	s.token = s.buf[:0]
+	s.borrowed = false
+
+	if skip {
+		// Skip the end of the line that contains the previous character
+		s.ScanLine()
+		s.line = 0
+	}
+	return nil
 }
 
-func (s *Scanner) handleNewLineSequence(currentNl, nextNl byte) {
-	if s.keepnls {
-		// Keep current newline character (relative to the seek)
-		s.token = append(s.token, currentNl)
-		s.limit = uint32(len(s.token))
-		s.recordNewlineSequence(currentNl)
+// delimLen returns the length in the file of the delimiter (of a newline character for AnyNewline).
+func (s *Scanner) delimLen() int {
+	if s.delim != nil {
+		return s.sourceLen(s.delim)
+	}
+	return s.nlLen
+}
+
+// trimDelimiter removes the delimiter (or the newline characters for AnyNewline) at the end of line.
+func (s *Scanner) trimDelimiter(line []byte) []byte {
+	if s.delim != nil {
+		return bytes.TrimSuffix(line, s.delim)
+	}
+	return TrimNewline(line)
+}
+
+// seek moves the underlying reader to the given offset of the file.
+func (s *Scanner) seek(offset uint64) error {
+	if _, err := s.f.Seek(int64(offset), io.SeekStart); err != nil {
+		return err
+	}
+	if s.enc != nil {
+		s.r.Reset(transform.NewReader(s.f, s.enc.NewDecoder()))
 	} else {
-		s.limit = uint32(len(s.token) + 1)
+		s.r.Reset(s.f)
 	}
+	return nil
+}
+
+// init skips the BOM and sets up the transcoding before reading the first line.
+func (s *Scanner) init() bool {
+	if !s.detected {
+		if s.detectBOM(); s.bom != nil || s.enc != nil {
+			if err := s.rewind(); err != nil {
+				s.setErr(err)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// nextLine moves the Scanner to the start of the next line.
+func (s *Scanner) nextLine() bool {
+	// Reuse the token's buffer
+	s.token = s.buf[:0]
+	s.borrowed = false
+	s.offset += s.limit
+	s.err = nil
 
+	// End of the bounded range
+	if s.end > 0 && s.offset >= s.end {
+		s.limit = 0
+		s.err = io.EOF
+		return false
+	}
+	s.line++
+	return true
+}
+
+// checkLine computes the length of the read line in the file and checks the maximum line length.
+func (s *Scanner) checkLine() bool {
+	length := s.limit
+	if s.enc != nil {
+		nls := int(s.limit) - len(s.token) // Dropped newline sequence
+		if s.delim != nil && nls > 0 {
+			nls = s.sourceLen(s.delim)
+		} else {
+			nls *= s.nlLen
+		}
+		s.limit = uint64(s.sourceLen(s.token) + nls)
+	}
+	if length > s.maxlen || s.limit > DefaultMaxLineLength {
+		return s.tooLong(true)
+	}
+	return true
+}
+
+// tooLong handles a line longer than the maximum line length.
+// The line is skipped or the Scanner is moved back to the start of the line, so it can be read with WriteLineTo.
+// consumed says if the whole line has been read.
+func (s *Scanner) tooLong(consumed bool) bool {
+	if s.skiplong {
+		if !consumed {
+			// The token holds the beginning of the line
+			n := uint64(s.sourceLen(s.token))
+			_, limit, err := s.streamLine(ioutil.Discard, false)
+			if err != nil && err != io.EOF {
+				s.err = err
+				return false
+			}
+			s.limit = n + limit
+		}
+		return s.scanLine()
+	}
+
+	s.err = &LineTooLongError{Line: s.line, Offset: s.offset}
+	s.line--
+	s.limit = 0
+	s.token = s.buf[:0]
+	s.borrowed = false
+	if err := s.seek(s.offset); err != nil {
+		s.err = err
+	}
+	return false
+}
+
+// streamLine writes the rest of the current line into w and consumes its newline sequence.
+// It returns the number of written bytes and the consumed length in the file.
+func (s *Scanner) streamLine(w io.Writer, nls bool) (int64, uint64, error) {
+	var n int64
+	var limit uint64
 	for {
-		b, err := s.r.Peek(1)
+		var chunk []byte
+		var err error
+		var i int
+		if s.delim != nil {
+			chunk, err = s.peekDelimited()
+			i = bytes.Index(chunk, s.delim)
+		} else {
+			chunk, err = s.peek()
+			i = indexNewline(chunk)
+		}
+		if len(chunk) == 0 {
+			return n, limit, err
+		}
+
+		part := chunk
+		if i >= 0 {
+			part = chunk[:i]
+		} else if s.delim != nil {
+			part = chunk[:safeLen(chunk, s.delim, err)]
+		}
+		m, err := w.Write(part)
+		n += int64(m)
+		limit += uint64(s.sourceLen(part))
+		s.r.Discard(len(part))
 		if err != nil {
-			s.err = err
-			return
+			return n, limit, err
+		}
+		if i < 0 {
+			continue
 		}
 
-		if b[0] == nextNl {
-			if s.keepnls {
-				// Keep next newline character (relative to the currentNl)
-				s.token = append(s.token, nextNl)
-				s.limit = uint32(len(s.token))
-				s.recordNewlineSequence(nextNl)
-			} else {
-				s.limit++
+		var seq []byte
+		if s.delim != nil {
+			seq = s.delim
+			s.r.Discard(len(seq))
+			limit += uint64(s.sourceLen(seq))
+		} else {
+			seq = []byte{chunk[i]}
+			s.r.Discard(1)
+			if b, err := s.r.Peek(1); err == nil && (b[0] == LF || b[0] == CR) && b[0] != seq[0] {
+				seq = append(seq, b[0])
+				s.r.Discard(1)
 			}
-			s.r.ReadByte()
-			return
+			limit += uint64(len(seq) * s.nlLen)
+		}
+		if nls {
+			m, err = w.Write(seq)
+			n += int64(m)
+		}
+		return n, limit, err
+	}
+}
+
+func (s *Scanner) handleNewLineSequence(currentNl, nextNl byte) {
+	if s.keepnls {
+		// Keep current newline character (relative to the seek)
+		s.appendNewline(currentNl)
+		s.limit = uint64(len(s.token))
+		s.recordNewlineSequence(currentNl, true)
+	} else {
+		s.limit = uint64(len(s.token) + 1)
+	}
+
+	if s.r.Buffered() == 0 {
+		// Peek is going to fill the read buffer and override the borrowed token
+		s.ownToken()
+	}
+
+	b, err := s.r.Peek(1)
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	if b[0] == nextNl {
+		if s.keepnls {
+			// Keep next newline character (relative to the currentNl)
+			s.appendNewline(nextNl)
+			s.limit = uint64(len(s.token))
+			s.recordNewlineSequence(nextNl, false)
+		} else {
+			s.limit++
+		}
+		s.r.Discard(1)
+	}
+}
+
+// peek returns all the buffered data, the read buffer is filled when it is empty.
+func (s *Scanner) peek() ([]byte, error) {
+	if s.r.Buffered() == 0 {
+		if _, err := s.r.Peek(1); err != nil {
+			return nil, err
 		}
+	}
+	return s.r.Peek(s.r.Buffered())
+}
+
+// peekDelimited returns all the buffered data, at least the length of the delimiter is buffered
+// unless the end of the file is reached (the error is then returned with the remaining data).
+func (s *Scanner) peekDelimited() ([]byte, error) {
+	var err error
+	if s.r.Buffered() < len(s.delim) {
+		_, err = s.r.Peek(len(s.delim))
+	}
+	chunk, _ := s.r.Peek(s.r.Buffered())
+	return chunk, err
+}
+
+// safeLen returns the length of the beginning of chunk that can not be a part of the delimiter
+// when chunk does not contain the delimiter. The end of chunk may be the beginning of the delimiter
+// unless there is no more data (err is not nil).
+func safeLen(chunk, delim []byte, err error) int {
+	if err != nil {
+		return len(chunk)
+	}
+	return len(chunk) - len(delim) + 1
+}
+
+// appendToken copies the given bytes at the end of the token.
+func (s *Scanner) appendToken(b []byte) {
+	s.ownToken()
+	s.buf = append(s.token, b...)
+	s.token = s.buf
+}
+
+// appendNewline appends the newline character that has just been read.
+func (s *Scanner) appendNewline(nl byte) {
+	if s.borrowed {
+		// The newline character follows the token in the read buffer
+		s.token = s.token[:len(s.token)+1]
+		return
+	}
+	s.buf = append(s.token, nl)
+	s.token = s.buf
+}
+
+// ownToken copies the borrowed token into the Scanner's buffer.
+func (s *Scanner) ownToken() {
+	if !s.borrowed {
 		return
 	}
+	s.buf = append(s.buf[:0], s.token...)
+	s.token = s.buf
+	s.borrowed = false
 }
 
-func (s *Scanner) recordNewlineSequence(b byte) {
+// indexNewline returns the index of the first LF or CR in b or -1 if there is no newline character.
+func indexNewline(b []byte) int {
+	i := bytes.IndexByte(b, LF)
+	if i < 0 {
+		return bytes.IndexByte(b, CR)
+	}
+	if j := bytes.IndexByte(b[:i], CR); j >= 0 {
+		return j
+	}
+	return i
+}
+
+func (s *Scanner) recordNewlineSequence(b byte, first bool) {
 	if s.line == 1 {
+		if first {
+			s.newlineSequence = s.newlineSequence[:0] // The first line may be read several times
+		}
 		s.newlineSequence = append(s.newlineSequence, b)
 	}
 }
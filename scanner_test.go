@@ -1,6 +1,12 @@
 package iosupport_test
 
 import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
 	. "github.com/mdouchement/iosupport"
 	"github.com/mdouchement/stringio"
 
@@ -84,6 +90,348 @@ var _ = Describe("Scanner", func() {
 				Expect(actual).To(ConsistOf("The first line.", "The second line.", ""))
 			})
 		})
+
+		Context("when lines are longer than the read buffer", func() {
+			var long = strings.Repeat("a", 10000)
+			var file = stringio.NewFromString(long + "\r\n" + strings.Repeat("b", 4095) + "\r\nc")
+			var subject = NewScanner(file)
+			var actual = []string{}
+			var limits = []uint32{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+				limits = append(limits, subject.Limit())
+			}
+
+			It("reads the file", func() {
+				Expect(actual).To(Equal([]string{long, strings.Repeat("b", 4095), "c"}))
+			})
+
+			It("computes the limits", func() {
+				Expect(limits).To(Uint32ConsistOf(10002, 4097, 1))
+			})
+		})
+	})
+
+	Describe("#NoCopy", func() {
+		var data = "The first line.\r\n" + strings.Repeat("a", 5000) + "\nThe third line.\r\r\n"
+
+		Context("without newline sequence", func() {
+			var subject = NewScanner(stringio.NewFromString(data))
+			subject.NoCopy(true)
+			var actual = []string{}
+			var offsets = []uint64{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+				offsets = append(offsets, subject.Offset())
+			}
+
+			It("reads the file", func() {
+				Expect(actual).To(Equal([]string{"The first line.", strings.Repeat("a", 5000), "The third line.", ""}))
+			})
+
+			It("computes the offsets", func() {
+				Expect(offsets).To(Uint64ConsistOf(0, 17, 5018, 5034))
+			})
+		})
+
+		Context("with newline sequence", func() {
+			var subject = NewScanner(stringio.NewFromString(data))
+			subject.NoCopy(true)
+			subject.KeepNewlineSequence(true)
+			var actual = []string{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+			}
+
+			It("reads the file", func() {
+				Expect(actual).To(Equal([]string{"The first line.\r\n", strings.Repeat("a", 5000) + "\n", "The third line.\r", "\r\n"}))
+			})
+		})
+	})
+
+	Describe("#SeekOffset", func() {
+		var data = "line1\nline2\r\nline3\nline4"
+
+		Context("when the offset is the beginning of a line", func() {
+			var subject = NewScanner(stringio.NewFromString(data))
+			check(subject.SeekOffset(6))
+			var actual = []string{}
+			var offsets = []uint64{}
+			var lines = []int{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+				offsets = append(offsets, subject.Offset())
+				lines = append(lines, subject.Line())
+			}
+
+			It("reads from the given line", func() {
+				Expect(actual).To(Equal([]string{"line2", "line3", "line4"}))
+			})
+
+			It("computes the offsets", func() {
+				Expect(offsets).To(Uint64ConsistOf(6, 13, 19))
+			})
+
+			It("counts the lines from the offset", func() {
+				Expect(lines).To(Equal([]int{1, 2, 3}))
+			})
+		})
+
+		Context("when the offset is inside a newline sequence", func() {
+			var subject = NewScanner(stringio.NewFromString(data))
+			check(subject.SeekOffset(12))
+			var actual = []string{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+			}
+
+			It("resynchronizes on the next line", func() {
+				Expect(actual).To(Equal([]string{"line3", "line4"}))
+			})
+		})
+
+		Context("when the Scanner is reset", func() {
+			var subject = NewScanner(stringio.NewFromString(data))
+			check(subject.SeekOffset(2))
+			var actual = []string{}
+
+			subject.EachString(func(line string, err error) {
+				check(err)
+				actual = append(actual, line)
+			})
+
+			It("rewinds to the given offset", func() {
+				Expect(actual).To(Equal([]string{"line2", "line3", "line4"}))
+			})
+		})
+	})
+
+	Describe("#StopAt", func() {
+		var data = "line1\nline2\r\nline3\nline4\n"
+
+		It("splits the file in byte ranges", func() {
+			for _, bounds := range [][]uint64{{0, 6, 13, 25}, {0, 3, 12, 14, 25}, {0, 1, 2, 20, 25}} {
+				var actual = []string{}
+				var offsets = []uint64{}
+
+				for i := 1; i < len(bounds); i++ {
+					subject := NewScanner(stringio.NewFromString(data))
+					check(subject.SeekOffset(bounds[i-1]))
+					subject.StopAt(bounds[i])
+
+					for subject.ScanLine() {
+						check(subject.Err())
+						actual = append(actual, subject.Text())
+						offsets = append(offsets, subject.Offset())
+					}
+				}
+
+				Expect(actual).To(Equal([]string{"line1", "line2", "line3", "line4"}))
+				Expect(offsets).To(Uint64ConsistOf(0, 6, 13, 19))
+			}
+		})
+	})
+
+	Describe("#MaxLineLength", func() {
+		var long = strings.Repeat("a", 5000)
+		var data = "line1\n" + long + "\nline3\r\nline4"
+
+		Context("when a line is too long", func() {
+			var subject = NewScanner(stringio.NewFromString(data))
+			subject.MaxLineLength(100)
+			var actual = []string{}
+
+			for subject.ScanLine() {
+				actual = append(actual, subject.Text())
+			}
+			err := subject.Err()
+
+			It("reads the lines before the long line", func() {
+				Expect(actual).To(Equal([]string{"line1"}))
+			})
+
+			It("returns an error with the offset of the line", func() {
+				Expect(errors.Is(err, ErrLineTooLong)).To(BeTrue())
+				Expect(err).To(Equal(&LineTooLongError{Line: 2, Offset: 6}))
+			})
+		})
+
+		Context("when the newline sequence exceeds the maximum line length", func() {
+			var subject = NewScanner(stringio.NewFromString(data))
+			subject.MaxLineLength(6)
+			var actual = []string{}
+
+			for subject.ScanLine() {
+				actual = append(actual, subject.Text())
+			}
+
+			It("includes the newline sequence in the length", func() {
+				Expect(actual).To(Equal([]string{"line1"}))
+				Expect(subject.Err()).To(Equal(&LineTooLongError{Line: 2, Offset: 6}))
+			})
+		})
+	})
+
+	Describe("#SkipLongLines", func() {
+		var long = strings.Repeat("a", 5000)
+		var data = "line1\n" + long + "\n" + long + "\r\nline3\r\nline4\n" + long
+
+		for _, keepnls := range []bool{false, true} {
+			var subject = NewScanner(stringio.NewFromString(data))
+			subject.KeepNewlineSequence(keepnls)
+			subject.MaxLineLength(7)
+			subject.SkipLongLines(true)
+			var actual = []string{}
+			var offsets = []uint64{}
+			var lines = []int{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, string(TrimNewline(subject.Bytes())))
+				offsets = append(offsets, subject.Offset())
+				lines = append(lines, subject.Line())
+			}
+
+			It("skips the long lines", func() {
+				Expect(actual).To(Equal([]string{"line1", "line3", "line4"}))
+				Expect(offsets).To(Uint64ConsistOf(0, 10009, 10016))
+				Expect(lines).To(Equal([]int{1, 4, 5}))
+			})
+		}
+	})
+
+	Describe("#WriteLineTo", func() {
+		var long = strings.Repeat("a", 5000)
+		var data = "line1\n" + long + "\r\nline3"
+		var subject = NewScanner(stringio.NewFromString(data))
+		subject.MaxLineLength(100)
+		var actual = []string{}
+		var streamed = stringio.New()
+
+		for subject.ScanLine() {
+			actual = append(actual, subject.Text())
+		}
+		n, err := subject.WriteLineTo(streamed)
+		check(err)
+		offset := subject.Offset()
+		limit := subject.Limit()
+		for subject.ScanLine() {
+			actual = append(actual, subject.Text())
+		}
+		_, eof := subject.WriteLineTo(streamed)
+
+		It("streams the long line", func() {
+			Expect(n).To(BeNumerically("==", 5000))
+			Expect(streamed.GetValueString()).To(Equal(long))
+			Expect(offset).To(BeNumerically("==", 6))
+			Expect(limit).To(BeNumerically("==", 5002))
+		})
+
+		It("continues the scan after the long line", func() {
+			Expect(actual).To(Equal([]string{"line1", "line3"}))
+			Expect(eof).To(Equal(io.EOF))
+		})
+	})
+
+	Describe("#SetDelimiter", func() {
+		var scan = func(data string, delim Delimiter, keepnls bool) ([]string, []uint64) {
+			var subject = NewScanner(stringio.NewFromString(data))
+			subject.SetDelimiter(delim)
+			subject.KeepNewlineSequence(keepnls)
+			var actual = []string{}
+			var offsets = []uint64{}
+
+			for subject.ScanLine() {
+				check(subject.Err())
+				actual = append(actual, subject.Text())
+				offsets = append(offsets, subject.Offset())
+			}
+			return actual, offsets
+		}
+
+		Context("with LF only", func() {
+			actual, offsets := scan("line\r1\nline2\r\nline3", LFDelimiter, false)
+
+			It("keeps the CR in the lines", func() {
+				Expect(actual).To(Equal([]string{"line\r1", "line2\r", "line3"}))
+				Expect(offsets).To(Uint64ConsistOf(0, 7, 14))
+			})
+		})
+
+		Context("with CRLF only", func() {
+			actual, offsets := scan("line\r1\r\nline\n2\r\n\r\n", CRLFDelimiter, false)
+
+			It("keeps the lone CR and LF in the lines", func() {
+				Expect(actual).To(Equal([]string{"line\r1", "line\n2", ""}))
+				Expect(offsets).To(Uint64ConsistOf(0, 8, 16))
+			})
+		})
+
+		Context("with NUL", func() {
+			actual, _ := scan("file 1\x00file\n2\x00", NULDelimiter, true)
+
+			It("splits the lines on null bytes", func() {
+				Expect(actual).To(Equal([]string{"file 1\x00", "file\n2\x00"}))
+			})
+		})
+
+		Context("with an empty delimiter", func() {
+			actual, _ := scan("a\nb\r\nc", Delimiter{}, false)
+
+			It("splits the lines on any newline sequence", func() {
+				Expect(actual).To(Equal([]string{"a", "b", "c"}))
+			})
+		})
+
+		Context("with a sequence of bytes across the read buffer", func() {
+			var long = strings.Repeat("a", 4095)
+			actual, offsets := scan(long+"<EOL>b<EO<EOL>c<EOL", Delimiter("<EOL>"), false)
+
+			It("splits the lines on the sequence", func() {
+				Expect(actual).To(Equal([]string{long, "b<EO", "c<EOL"}))
+				Expect(offsets).To(Uint64ConsistOf(0, 4100, 4109))
+			})
+		})
+
+		Context("when the scanner seeks an offset", func() {
+			var subject = NewScanner(stringio.NewFromString("line1||line2||line3"))
+			subject.SetDelimiter(Delimiter("||"))
+			check(subject.SeekOffset(7))
+			var actual = []string{}
+
+			for subject.ScanLine() {
+				actual = append(actual, subject.Text())
+			}
+
+			It("reads from the given line", func() {
+				Expect(actual).To(Equal([]string{"line2", "line3"}))
+			})
+		})
+
+		Context("when a line is streamed", func() {
+			var subject = NewScanner(stringio.NewFromString("line1||line2||"))
+			subject.SetDelimiter(Delimiter("||"))
+			var streamed = stringio.New()
+
+			_, err := subject.WriteLineTo(streamed)
+			check(err)
+			subject.ScanLine()
+
+			It("stops the stream at the delimiter", func() {
+				Expect(streamed.GetValueString()).To(Equal("line1"))
+				Expect(subject.Text()).To(Equal("line2"))
+			})
+		})
 	})
 
 	Describe("#KeepNewlineSequence", func() {
@@ -235,3 +583,70 @@ var _ = Describe("Scanner", func() {
 		})
 	})
 })
+
+// ------------------ //
+// Benchmarks         //
+// ------------------ //
+
+var benchmarkData = strings.Repeat("c1,c2,c3,c4,c5,c6,\"c,7\",c8,c9,10\n", 20000) + strings.Repeat("a", 20000) + "\r\n"
+
+// legacyScanLine is the former byte-per-byte implementation of Scanner.ScanLine (used as reference).
+func legacyScanLine(r *bufio.Reader) ([]byte, bool) {
+	token := make([]byte, 0)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return token, len(token) > 0
+		}
+
+		switch b {
+		case '\n', '\r':
+			next := byte('\r')
+			if b == '\r' {
+				next = '\n'
+			}
+			if p, err := r.Peek(1); err == nil && p[0] == next {
+				r.ReadByte()
+			}
+			return token, true
+		default:
+			token = append(token, b)
+		}
+	}
+}
+
+func BenchmarkScanLineLegacy(b *testing.B) {
+	b.SetBytes(int64(len(benchmarkData)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := bufio.NewReader(strings.NewReader(benchmarkData))
+		for _, ok := legacyScanLine(r); ok; _, ok = legacyScanLine(r) {
+		}
+	}
+}
+
+func BenchmarkScanLine(b *testing.B) {
+	benchmarkScanLine(b, false)
+}
+
+func BenchmarkScanLineNoCopy(b *testing.B) {
+	benchmarkScanLine(b, true)
+}
+
+func benchmarkScanLine(b *testing.B, nocopy bool) {
+	file := stringio.NewFromString(benchmarkData)
+	sc := NewScanner(file)
+	sc.NoCopy(nocopy)
+
+	b.SetBytes(int64(len(benchmarkData)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sc.Reset()
+		for sc.ScanLine() {
+		}
+		if err := sc.Err(); err != nil && err != io.EOF {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package iosupport
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+)
+
+// Sniff guesses the dialect of a TSV from its first lines:
+//
+// sc := iosupport.NewScanner(file)
+// dialect, _ := iosupport.Sniff(sc, 100)
+// parser := iosupport.NewTsvParser(sc, ',')
+// parser.SetSeparator(dialect.Separator())
+//
+// The separator candidates are ranked by the consistency of the number of fields across the sampled rows.
+// An Excel `sep=;' first line defines the separator, the rows start on the next line (see Dialect.Offset).
+// The header is likely when the first row differs from the other ones (e.g. text in a numeric column).
+
+// DefaultSniffLines is the number of sampled lines when Sniff is called without a sample size.
+const DefaultSniffLines = 100
+
+// sniffedSeparators are the separator candidates, the first ones are preferred on equal consistency.
+var sniffedSeparators = []byte{',', '\t', ';', '|', ':'}
+
+// A Dialect describes the format of a TSV guessed by Sniff.
+type Dialect struct {
+	Separators      [][]byte // Separator candidates, the most likely first.
+	QuoteChar       byte     // 0 when no quoted field has been found.
+	Header          bool     // The first row is likely a header.
+	NewlineSequence []byte   // Newline sequence of the first line (nil when there is no newline).
+	BOM             []byte   // Byte order mark found at the beginning of the file.
+	SepLine         bool     // The first line is an Excel `sep=' line.
+	Offset          uint64   // Offset of the first row (after the BOM and the `sep=' line).
+}
+
+// Separator returns the most likely separator (`,' when no candidate has been found).
+func (d *Dialect) Separator() []byte {
+	if len(d.Separators) == 0 {
+		return []byte{','}
+	}
+	return d.Separators[0]
+}
+
+// Sniff reads the sampleLines first lines of the Scanner and guesses the dialect of the TSV.
+// The Scanner is reset before and after the sniffing, a sampleLines lower than 1 means DefaultSniffLines.
+func Sniff(sc *Scanner, sampleLines int) (*Dialect, error) {
+	if sampleLines < 1 {
+		sampleLines = DefaultSniffLines
+	}
+	keepnls := sc.keepnls
+	sc.KeepNewlineSequence(true)
+	sc.Reset()
+	defer func() {
+		sc.KeepNewlineSequence(keepnls)
+		sc.Reset()
+	}()
+
+	d := &Dialect{}
+	lines := [][]byte{}
+	for len(lines) < sampleLines && sc.ScanLine() {
+		line := sc.trimDelimiter(sc.Bytes())
+		if len(lines) == 0 {
+			d.Offset = sc.Offset()
+			if ns := sc.Bytes()[len(line):]; len(ns) > 0 {
+				d.NewlineSequence = append([]byte{}, ns...)
+			}
+			if bytes.HasPrefix(line, []byte("sep=")) && len(line) > len("sep=") {
+				d.SepLine = true
+				d.Separators = [][]byte{UnescapeSeparatorBytes(string(line[len("sep="):]))}
+				d.Offset += uint64(sc.Limit())
+				continue
+			}
+		}
+		lines = append(lines, append([]byte{}, line...))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	d.BOM = sc.BOM()
+
+	d.QuoteChar = sniffQuoteChar(lines)
+	records := sniffRecords(lines, d.QuoteChar)
+	if !d.SepLine {
+		d.Separators = sniffSeparators(records, d.QuoteChar)
+	}
+	d.Header = sniffHeader(records, d.Separator(), d.QuoteChar)
+	return d, nil
+}
+
+// sniffQuoteChar returns the quote character found at the beginning of the most fields.
+func sniffQuoteChar(lines [][]byte) byte {
+	var quote byte
+	max := 0
+	for _, q := range []byte{'"', '\''} {
+		n := 0
+		for _, line := range lines {
+			for i, b := range line {
+				if b == q && (i == 0 || bytes.IndexByte(sniffedSeparators, line[i-1]) >= 0) {
+					n++
+				}
+			}
+		}
+		if n > max {
+			quote, max = q, n
+		}
+	}
+	return quote
+}
+
+// sniffRecords joins the lines of the records that contain quoted newlines.
+func sniffRecords(lines [][]byte, quote byte) [][]byte {
+	records := [][]byte{}
+	open := false
+	for _, line := range lines {
+		if open {
+			i := len(records) - 1
+			records[i] = append(append(records[i], LF), line...)
+		} else {
+			records = append(records, line)
+		}
+		if quote != 0 && bytes.Count(line, []byte{quote})%2 == 1 {
+			open = !open
+		}
+	}
+	return records
+}
+
+// sniffSeparators ranks the separator candidates by the consistency of the number of fields.
+func sniffSeparators(records [][]byte, quote byte) [][]byte {
+	type candidate struct {
+		separator   byte
+		fields      int     // Most frequent number of fields.
+		consistency float64 // Ratio of the records with the most frequent number of fields.
+	}
+
+	candidates := []candidate{}
+	for _, separator := range sniffedSeparators {
+		frequencies := map[int]int{}
+		for _, record := range records {
+			frequencies[len(splitSniffedFields(record, separator, quote))]++
+		}
+
+		c := candidate{separator: separator}
+		for fields, n := range frequencies {
+			if n > frequencies[c.fields] || (n == frequencies[c.fields] && fields > c.fields) {
+				c.fields = fields
+			}
+		}
+		if c.fields < 2 {
+			continue // The separator is not found
+		}
+		c.consistency = float64(frequencies[c.fields]) / float64(len(records))
+		candidates = append(candidates, c)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].consistency != candidates[j].consistency {
+			return candidates[i].consistency > candidates[j].consistency
+		}
+		return candidates[i].fields > candidates[j].fields
+	})
+
+	separators := make([][]byte, len(candidates))
+	for i, c := range candidates {
+		separators[i] = []byte{c.separator}
+	}
+	return separators
+}
+
+// sniffHeader votes for each column whether the first row differs from the other ones.
+func sniffHeader(records [][]byte, separator []byte, quote byte) bool {
+	if len(records) < 2 || len(separator) != 1 {
+		return false
+	}
+
+	header := splitSniffedFields(records[0], separator[0], quote)
+	names := map[string]bool{}
+	for _, name := range header {
+		if len(name) == 0 || names[string(name)] {
+			return false // Not a valid header
+		}
+		names[string(name)] = true
+	}
+
+	rows := [][][]byte{}
+	for _, record := range records[1:] {
+		rows = append(rows, splitSniffedFields(record, separator[0], quote))
+	}
+
+	votes := 0
+	for i, name := range header {
+		numeric, length := true, -1
+		for _, row := range rows {
+			if i >= len(row) || len(row[i]) == 0 {
+				continue
+			}
+			if !isSniffedNumber(row[i]) {
+				numeric = false
+			}
+			if length == -1 {
+				length = len(row[i])
+			} else if length != len(row[i]) {
+				length = -2 // Variable length
+			}
+		}
+
+		switch {
+		case length == -1:
+			// Empty column
+		case numeric && !isSniffedNumber(name):
+			votes++
+		case numeric:
+			votes--
+		case length >= 0 && length != len(name):
+			votes++
+		case length >= 0:
+			votes--
+		}
+	}
+	return votes > 0
+}
+
+// splitSniffedFields splits the record on the separator outside of the quoted fields.
+// The quotes are removed from the fields.
+func splitSniffedFields(record []byte, separator, quote byte) [][]byte {
+	fields := [][]byte{}
+	field := []byte{}
+	open := false
+	for i := 0; i < len(record); i++ {
+		b := record[i]
+		switch {
+		case quote != 0 && b == quote && open && i+1 < len(record) && record[i+1] == quote:
+			field = append(field, b) // Escaped quote
+			i++
+		case quote != 0 && b == quote:
+			open = !open
+		case b == separator && !open:
+			fields = append(fields, field)
+			field = []byte{}
+		default:
+			field = append(field, b)
+		}
+	}
+	return append(fields, field)
+}
+
+func isSniffedNumber(field []byte) bool {
+	_, err := strconv.ParseFloat(string(bytes.TrimSpace(field)), 64)
+	return err == nil
+}
@@ -0,0 +1,92 @@
+package iosupport_test
+
+import (
+	. "github.com/mdouchement/iosupport"
+	"github.com/mdouchement/stringio"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sniff", func() {
+	var sniff = func(data string) *Dialect {
+		sc := NewScanner(stringio.NewFromString(data))
+		dialect, err := Sniff(sc, 10)
+		check(err)
+
+		// The Scanner is reset
+		Expect(sc.ScanLine()).To(BeTrue())
+		Expect(sc.Line()).To(Equal(1))
+		return dialect
+	}
+
+	Context("with a semicolon separated file", func() {
+		var dialect = sniff("name;age;score\nAlice;42;1,5\nBob;7;3,25\nCarol;30;0\n")
+
+		It("ranks the separator candidates", func() {
+			Expect(dialect.Separator()).To(Equal([]byte(";")))
+			Expect(dialect.Separators[0]).To(Equal([]byte(";")))
+		})
+
+		It("detects the header", func() {
+			Expect(dialect.Header).To(BeTrue())
+		})
+
+		It("detects the newline sequence", func() {
+			Expect(dialect.NewlineSequence).To(Equal([]byte("\n")))
+			Expect(dialect.QuoteChar).To(BeZero())
+			Expect(dialect.SepLine).To(BeFalse())
+			Expect(dialect.Offset).To(BeZero())
+		})
+	})
+
+	Context("with a tab separated file without header", func() {
+		var dialect = sniff("1\t2.5\tfoo\r\n3\t4\tbar\r\n5\t6\tbaz\r\n")
+
+		It("detects the dialect", func() {
+			Expect(dialect.Separator()).To(Equal([]byte("\t")))
+			Expect(dialect.Header).To(BeFalse())
+			Expect(dialect.NewlineSequence).To(Equal([]byte("\r\n")))
+		})
+	})
+
+	Context("with quoted fields", func() {
+		var dialect = sniff("id,comment\n1,\"a;b|c\"\n2,\"multi\nline, with comma\"\n3,\"\"\"quoted\"\"\"\n")
+
+		It("detects the quote char", func() {
+			Expect(dialect.QuoteChar).To(Equal(byte('"')))
+		})
+
+		It("ignores the separators of the quoted fields", func() {
+			Expect(dialect.Separator()).To(Equal([]byte(",")))
+			Expect(dialect.Header).To(BeTrue())
+		})
+	})
+
+	Context("with an Excel sep line and a BOM", func() {
+		var dialect = sniff("\xEF\xBB\xBFsep=|\nc1|c2\n1|2\n")
+
+		It("uses the given separator", func() {
+			Expect(dialect.Separators).To(Equal([][]byte{[]byte("|")}))
+			Expect(dialect.SepLine).To(BeTrue())
+		})
+
+		It("detects the BOM", func() {
+			Expect(dialect.BOM).To(Equal(UTF8BOM))
+		})
+
+		It("returns the offset of the first row", func() {
+			Expect(dialect.Offset).To(BeEquivalentTo(9))
+			Expect(dialect.Header).To(BeTrue())
+		})
+	})
+
+	Context("without separator", func() {
+		var dialect = sniff("foo\nbar\n")
+
+		It("falls back to the comma", func() {
+			Expect(dialect.Separators).To(BeEmpty())
+			Expect(dialect.Separator()).To(Equal([]byte(",")))
+		})
+	})
+})
@@ -0,0 +1,425 @@
+package iosupport
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// A SortKey defines how a field is compared by a TsvIndexer.
+// It is given by the SortKeys option or parsed from a field of the Fields option (see ParseSortKey):
+//
+//	iosupport.Fields("revenue:int:desc", "date:time=02/01/2006", "price:float=,", "release:version", "size:size")
+//	iosupport.Fields("name:collate=fr", "city:fold:nfkd")
+//
+// Each typed value is encoded into an order-preserving Comparable, so the in-memory sort and the merge of
+// the swapped lines both respect the type and the direction. Empty values sort first, then the values that cannot
+// be parsed (compared as strings), then the parsed values. This order is reversed for a descending key.
+type SortKey struct {
+	Field        string  // Name of the column (varN without header)
+	Type         KeyType // How the values are compared (StringKey by default)
+	Layout       string  // Layout of a TimeKey (RFC 3339 by default)
+	DecimalComma bool    // The decimal separator of a FloatKey is a comma (`.' and spaces are digit grouping)
+	Desc         bool    // The values are sorted in descending order
+
+	Fold          bool          // The values are compared case-insensitively (Unicode case folding)
+	Normalization Normalization // Unicode normalization of the values (e.g. NFKD with Fold to ignore the ligatures)
+	Collation     string        // BCP 47 tag of the locale collation of a StringKey (e.g. `fr', `de-u-co-phonebk' or `und')
+
+	compiled *keyCollation // Built from the collation options by compile
+}
+
+// A KeyType defines how the values of a SortKey are compared.
+type KeyType int
+
+const (
+	// StringKey compares the raw bytes of the values.
+	StringKey KeyType = iota
+	// IntKey compares the values as integers.
+	IntKey
+	// FloatKey compares the values as floating-point numbers.
+	FloatKey
+	// TimeKey compares the values as dates parsed with the Layout of the SortKey.
+	TimeKey
+	// VersionKey compares the values as version numbers like `sort -V' (e.g. 1.9 < 1.10).
+	VersionKey
+	// SizeKey compares the values as human readable sizes like `sort -h' (e.g. 900K < 1.5M).
+	SizeKey
+)
+
+// A Normalization is a Unicode normalization form applied to the values of a SortKey.
+type Normalization int
+
+const (
+	// NoNormalization compares the values as they are.
+	NoNormalization Normalization = iota
+	// NFC normalizes the values to the canonical composition.
+	NFC
+	// NFD normalizes the values to the canonical decomposition.
+	NFD
+	// NFKC normalizes the values to the compatibility composition.
+	NFKC
+	// NFKD normalizes the values to the compatibility decomposition.
+	NFKD
+)
+
+var normalizations = map[string]Normalization{
+	"nfc":  NFC,
+	"nfd":  NFD,
+	"nfkc": NFKC,
+	"nfkd": NFKD,
+}
+
+var normForms = map[Normalization]norm.Form{
+	NFC:  norm.NFC,
+	NFD:  norm.NFD,
+	NFKC: norm.NFKC,
+	NFKD: norm.NFKD,
+}
+
+var keyTypes = map[string]KeyType{
+	"string":  StringKey,
+	"int":     IntKey,
+	"float":   FloatKey,
+	"time":    TimeKey,
+	"version": VersionKey,
+	"size":    SizeKey,
+}
+
+// Directions of a SortKey
+var keyDirections = map[string]bool{
+	"asc":  false,
+	"desc": true,
+}
+
+// descKeyTerminator ends an inverted key, it is greater than any inverted byte of a key
+const descKeyTerminator = '\xff'
+
+// Tags of the encoded values, the empty values are not tagged
+const (
+	invalidValueTag = '\x01'
+	validValueTag   = '\x02'
+)
+
+// maxVersionDigits is the maximum length of a number of a VersionKey, the length prefix is lower than 0xff
+const maxVersionDigits = 0xfe - validValueTag - 1
+
+// decimalCommaReplacer removes the digit grouping of a number with a decimal comma and replaces the comma by a dot
+var decimalCommaReplacer = strings.NewReplacer(".", "", " ", "", "\u00a0", "", "\u202f", "", ",", ".")
+
+// sizeUnits are the suffixes of the human readable sizes
+const sizeUnits = "KMGTPEZY"
+
+// ParseSortKey parses a field of the Fields option: `name[:type[=argument]][:modifier...]'.
+// The types are string, int, float (`float=,' for a decimal comma), time (`time=layout' with a Go layout), version and size.
+// The modifiers are asc, desc, fold (case-insensitive), nfc, nfd, nfkc, nfkd and collate (`collate=fr', root locale without tag).
+// The name ends at the first known type or modifier, so a name may contain colons (e.g. `a:b').
+func ParseSortKey(spec string) (SortKey, error) {
+	tokens := strings.Split(spec, ":")
+	key := SortKey{Field: tokens[0]}
+	var modifier string
+	for _, token := range tokens[1:] {
+		if !isKeyModifier(strings.SplitN(token, "=", 2)[0]) {
+			if modifier == "" {
+				key.Field += ":" + token // Part of the name
+			} else {
+				modifier += ":" + token // Part of the argument (e.g. `time=15:04')
+			}
+			continue
+		}
+		if err := key.apply(modifier); err != nil {
+			return key, err
+		}
+		modifier = token
+	}
+	return key, key.apply(modifier)
+}
+
+// isKeyModifier says if the name is a type or a modifier of a SortKey.
+func isKeyModifier(name string) bool {
+	_, isType := keyTypes[name]
+	_, isDirection := keyDirections[name]
+	_, isNormalization := normalizations[name]
+	return isType || isDirection || isNormalization || name == "fold" || name == "collate"
+}
+
+// apply sets the given `type[=argument]' or `modifier[=argument]' to the key.
+func (k *SortKey) apply(modifier string) error {
+	if modifier == "" {
+		return nil
+	}
+	parts := strings.SplitN(modifier, "=", 2)
+	argument := ""
+	if len(parts) == 2 {
+		argument = parts[1]
+	}
+
+	if parts[0] == "collate" {
+		k.Collation = argument
+		if argument == "" {
+			k.Collation = "und" // Root collation
+		}
+		return nil
+	}
+	if _, isType := keyTypes[parts[0]]; !isType && len(parts) == 2 {
+		return fmt.Errorf("sort key %s: invalid argument %q for %s", k.Field, argument, parts[0])
+	}
+	if desc, ok := keyDirections[parts[0]]; ok {
+		k.Desc = desc
+		return nil
+	}
+	if normalization, ok := normalizations[parts[0]]; ok {
+		k.Normalization = normalization
+		return nil
+	}
+	if parts[0] == "fold" {
+		k.Fold = true
+		return nil
+	}
+
+	k.Type = keyTypes[parts[0]]
+	switch {
+	case k.Type == TimeKey:
+		k.Layout = argument
+	case k.Type == FloatKey && argument == ",":
+		k.DecimalComma = true
+	case argument != "" && (k.Type != FloatKey || argument != "."):
+		return fmt.Errorf("sort key %s: invalid argument %q for %s", k.Field, argument, parts[0])
+	}
+	return nil
+}
+
+// compile validates the collation options and builds the transformations of the values.
+func (k *SortKey) compile() error {
+	k.compiled = nil
+	if !k.Fold && k.Normalization == NoNormalization && k.Collation == "" {
+		return nil
+	}
+
+	c := &keyCollation{}
+	if k.Normalization != NoNormalization {
+		form, ok := normForms[k.Normalization]
+		if !ok {
+			return fmt.Errorf("sort key %s: invalid normalization %d", k.Field, k.Normalization)
+		}
+		c.form = &form
+	}
+	if k.Collation != "" {
+		if k.Type != StringKey {
+			return fmt.Errorf("sort key %s: collation of a typed key", k.Field)
+		}
+		tag, err := language.Parse(k.Collation)
+		if err != nil {
+			return fmt.Errorf("sort key %s: invalid collation %q: %v", k.Field, k.Collation, err)
+		}
+		var options []collate.Option
+		if k.Fold {
+			options = append(options, collate.IgnoreCase)
+		}
+		c.collator = collate.New(tag, options...)
+	} else if k.Fold {
+		caser := cases.Fold()
+		c.caser = &caser
+	}
+	k.compiled = c
+	return nil
+}
+
+// appendKey appends the order-preserving encoding of the given value to dst.
+// The encoding never contains COMPARABLE_SEPARATOR (unless the value does).
+// The bytes of a descending key are inverted and followed by descKeyTerminator, so a longer key comes first.
+func (k *SortKey) appendKey(dst, value []byte) []byte {
+	if !k.Desc {
+		return k.appendAscKey(dst, value)
+	}
+
+	n := len(dst)
+	dst = k.appendAscKey(dst, value)
+	for i := n; i < len(dst); i++ {
+		dst[i] = ^dst[i]
+	}
+	return append(dst, descKeyTerminator)
+}
+
+// appendAscKey appends the ascending encoding of the given value to dst, it is empty for an empty value.
+func (k *SortKey) appendAscKey(dst, value []byte) []byte {
+	if k.compiled != nil && len(value) > 0 {
+		value = k.compiled.transform(value)
+		if k.compiled.collator != nil {
+			return k.compiled.appendKey(dst, value)
+		}
+	}
+	if len(value) == 0 || k.Type == StringKey {
+		return append(dst, value...)
+	}
+
+	n := len(dst)
+	var ok bool
+	switch k.Type {
+	case IntKey:
+		dst, ok = appendIntKey(dst, value)
+	case FloatKey:
+		dst, ok = appendFloatKey(dst, value, k.DecimalComma)
+	case TimeKey:
+		dst, ok = appendTimeKey(dst, value, k.Layout)
+	case VersionKey:
+		dst, ok = appendVersionKey(dst, value), true
+	case SizeKey:
+		dst, ok = appendSizeKey(dst, value)
+	}
+	if !ok {
+		dst = append(dst[:n], invalidValueTag)
+		return append(dst, value...)
+	}
+	return dst
+}
+
+func appendIntKey(dst, value []byte) ([]byte, bool) {
+	n, err := strconv.ParseInt(string(bytes.TrimSpace(value)), 10, 64)
+	if err != nil {
+		return dst, false
+	}
+	return appendUint64Key(dst, uint64(n)^(1<<63)), true // The sign bit is flipped so the negative numbers come first
+}
+
+func appendFloatKey(dst, value []byte, decimalComma bool) ([]byte, bool) {
+	s := string(bytes.TrimSpace(value))
+	if decimalComma {
+		s = decimalCommaReplacer.Replace(s)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return dst, false
+	}
+	return appendFloat64Key(dst, f), true
+}
+
+func appendTimeKey(dst, value []byte, layout string) ([]byte, bool) {
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	t, err := time.Parse(layout, string(bytes.TrimSpace(value)))
+	if err != nil {
+		return dst, false
+	}
+	dst = appendUint64Key(dst, uint64(t.Unix())^(1<<63))
+	return appendHex(dst, uint64(t.Nanosecond()), 4), true
+}
+
+// appendVersionKey compares the digit sequences as numbers and the other ones as strings.
+func appendVersionKey(dst, value []byte) []byte {
+	for len(value) > 0 {
+		i := bytes.IndexFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+		if i == 0 {
+			// Text part, it ends with a byte lower than any text byte and any number
+			if i = bytes.IndexAny(value, "0123456789"); i < 0 {
+				i = len(value)
+			}
+			dst = append(dst, value[:i]...)
+			dst = append(dst, invalidValueTag)
+			value = value[i:]
+			continue
+		}
+		if i < 0 {
+			i = len(value)
+		}
+
+		// Number part, it is prefixed by its length (without the leading zeros)
+		digits := bytes.TrimLeft(value[:i], "0")
+		if len(digits) > maxVersionDigits {
+			digits = digits[:maxVersionDigits] // Too long to be a version number
+		}
+		dst = append(dst, validValueTag, byte(validValueTag+1+len(digits)))
+		dst = append(dst, digits...)
+		value = value[i:]
+	}
+	return dst
+}
+
+// appendSizeKey parses a number followed by an optional unit (e.g. `512', `1.5K', `2 MB' or `3GiB').
+func appendSizeKey(dst, value []byte) ([]byte, bool) {
+	s := strings.TrimSpace(string(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "i")
+	multiplier := 1.0
+	if len(s) > 0 {
+		if i := strings.IndexByte(sizeUnits, s[len(s)-1]&^0x20); i >= 0 { // Case insensitive unit
+			multiplier = math.Pow(1024, float64(i+1))
+			s = s[:len(s)-1]
+		}
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(f) {
+		return dst, false
+	}
+	return appendFloat64Key(dst, f*multiplier), true
+}
+
+// appendFloat64Key encodes f so the encodings are ordered like the numbers.
+func appendFloat64Key(dst []byte, f float64) []byte {
+	bits := math.Float64bits(f)
+	if bits>>63 == 0 {
+		bits ^= 1 << 63 // Positive numbers come after the negative ones
+	} else {
+		bits = ^bits // The order of the negative numbers is reversed
+	}
+	return appendUint64Key(dst, bits)
+}
+
+// appendUint64Key encodes n as tagged fixed length hexadecimal (it never contains COMPARABLE_SEPARATOR).
+func appendUint64Key(dst []byte, n uint64) []byte {
+	return appendHex(append(dst, validValueTag), n, 8)
+}
+
+// appendHex appends the size last bytes of n in hexadecimal.
+func appendHex(dst []byte, n uint64, size int) []byte {
+	const digits = "0123456789abcdef"
+	for shift := uint(size*8 - 4); ; shift -= 4 {
+		dst = append(dst, digits[n>>shift&0xf])
+		if shift == 0 {
+			return dst
+		}
+	}
+}
+
+// A keyCollation transforms the values of a SortKey with its collation options.
+// It is not safe for concurrent use.
+type keyCollation struct {
+	form     *norm.Form
+	caser    *cases.Caser
+	collator *collate.Collator
+	buf      collate.Buffer
+}
+
+// transform normalizes and folds the value.
+func (c *keyCollation) transform(value []byte) []byte {
+	if c.form != nil {
+		value = c.form.Bytes(value)
+	}
+	if c.caser != nil {
+		value = c.caser.Bytes(value)
+	}
+	return value
+}
+
+// appendKey appends the collation key of the value to dst.
+// The key is escaped so it never contains COMPARABLE_SEPARATOR: 0x00 -> 0x01 0x01 and 0x01 -> 0x01 0x02.
+func (c *keyCollation) appendKey(dst, value []byte) []byte {
+	c.buf.Reset()
+	for _, b := range c.collator.Key(&c.buf, value) {
+		if b <= 0x01 {
+			dst = append(dst, 0x01, b+1)
+			continue
+		}
+		dst = append(dst, b)
+	}
+	return dst
+}
@@ -0,0 +1,261 @@
+package iosupport_test
+
+import (
+	"strings"
+
+	. "github.com/mdouchement/iosupport"
+	"github.com/mdouchement/stringio"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// sortedValues sorts the given values of a single column TSV on the given field.
+func sortedValues(values []string, options ...Option) []string {
+	sc := scanner("v\n" + strings.Join(values, "\n") + "\n")
+	subject := NewTsvIndexer(sc, append([]Option{HasHeader(), Separator("\t")}, options...)...)
+	output := stringio.New()
+
+	check(subject.Analyze())
+	subject.Sort()
+	check(subject.Transfer(output))
+	return strings.Split(strings.TrimSuffix(output.GetValueString(), "\n"), "\n")[1:]
+}
+
+var _ = Describe("SortKey", func() {
+	Describe("ParseSortKey", func() {
+		It("parses a plain field", func() {
+			key, err := ParseSortKey("revenue")
+			check(err)
+			Expect(key).To(Equal(SortKey{Field: "revenue"}))
+		})
+
+		It("parses the type", func() {
+			key, err := ParseSortKey("revenue:int")
+			check(err)
+			Expect(key).To(Equal(SortKey{Field: "revenue", Type: IntKey}))
+		})
+
+		It("parses a float with a decimal comma", func() {
+			key, err := ParseSortKey("price:float=,")
+			check(err)
+			Expect(key).To(Equal(SortKey{Field: "price", Type: FloatKey, DecimalComma: true}))
+		})
+
+		It("parses a time layout that contains colons", func() {
+			key, err := ParseSortKey("date:time=02/01/2006 15:04:05")
+			check(err)
+			Expect(key).To(Equal(SortKey{Field: "date", Type: TimeKey, Layout: "02/01/2006 15:04:05"}))
+		})
+
+		It("keeps the colons of the name", func() {
+			key, err := ParseSortKey("a:b:version")
+			check(err)
+			Expect(key).To(Equal(SortKey{Field: "a:b", Type: VersionKey}))
+		})
+
+		It("parses the direction", func() {
+			key, err := ParseSortKey("revenue:int:desc")
+			check(err)
+			Expect(key).To(Equal(SortKey{Field: "revenue", Type: IntKey, Desc: true}))
+		})
+
+		It("parses the direction after a time layout", func() {
+			key, err := ParseSortKey("date:time=15:04:desc")
+			check(err)
+			Expect(key).To(Equal(SortKey{Field: "date", Type: TimeKey, Layout: "15:04", Desc: true}))
+		})
+
+		It("parses the collation modifiers", func() {
+			key, err := ParseSortKey("city:fold:nfkd:collate=sv:desc")
+			check(err)
+			Expect(key).To(Equal(SortKey{Field: "city", Fold: true, Normalization: NFKD, Collation: "sv", Desc: true}))
+		})
+
+		It("parses the root collation", func() {
+			key, err := ParseSortKey("name:collate")
+			check(err)
+			Expect(key).To(Equal(SortKey{Field: "name", Collation: "und"}))
+		})
+
+		It("rejects an argument of the direction", func() {
+			_, err := ParseSortKey("revenue:desc=1")
+			Expect(err).To(MatchError(`sort key revenue: invalid argument "1" for desc`))
+		})
+
+		It("rejects an invalid argument", func() {
+			_, err := ParseSortKey("size:int=42")
+			Expect(err).To(MatchError(`sort key size: invalid argument "42" for int`))
+		})
+	})
+
+	Describe("with a TsvIndexer", func() {
+		It("sorts the integers", func() {
+			Expect(sortedValues([]string{"10", "9", "-3", "", "x", "0"}, Fields("v:int"))).To(
+				Equal([]string{"", "x", "-3", "0", "9", "10"}))
+		})
+
+		It("sorts the floats", func() {
+			Expect(sortedValues([]string{"1e3", "-0.5", "2.25", "-10", "NaN"}, Fields("v:float"))).To(
+				Equal([]string{"NaN", "-10", "-0.5", "2.25", "1e3"}))
+		})
+
+		It("sorts the floats with a decimal comma", func() {
+			Expect(sortedValues([]string{"1.234,5", "99,9", "-1,5"}, Fields("v:float=,"))).To(
+				Equal([]string{"-1,5", "99,9", "1.234,5"}))
+		})
+
+		It("sorts the dates", func() {
+			Expect(sortedValues([]string{"02/01/2016", "31/12/2015", "01/02/2016"}, Fields("v:time=02/01/2006"))).To(
+				Equal([]string{"31/12/2015", "02/01/2016", "01/02/2016"}))
+		})
+
+		It("sorts the versions", func() {
+			Expect(sortedValues([]string{"1.10", "1.9", "1.9.1", "1.09a", "v2", "1.2-rc1"}, Fields("v:version"))).To(
+				Equal([]string{"1.2-rc1", "1.9", "1.9.1", "1.09a", "1.10", "v2"}))
+		})
+
+		It("sorts the human readable sizes", func() {
+			Expect(sortedValues([]string{"1.5M", "900K", "2G", "512", "1KiB", "3 MB"}, Fields("v:size"))).To(
+				Equal([]string{"512", "1KiB", "900K", "1.5M", "3 MB", "2G"}))
+		})
+
+		It("sorts with the SortKeys option", func() {
+			Expect(sortedValues([]string{"10", "9"}, SortKeys(SortKey{Field: "v", Type: IntKey}))).To(
+				Equal([]string{"9", "10"}))
+		})
+
+		It("sorts the strings in descending order", func() {
+			Expect(sortedValues([]string{"b", "", "ab", "a", "c"}, Fields("v:desc"))).To(
+				Equal([]string{"c", "b", "ab", "a", ""}))
+		})
+
+		It("sorts the typed values in descending order", func() {
+			Expect(sortedValues([]string{"10", "9", "-3", "", "x", "0"}, Fields("v:int:desc"))).To(
+				Equal([]string{"10", "9", "0", "-3", "x", ""}))
+		})
+
+		It("sorts each field in its own direction", func() {
+			sc := scanner("country,revenue\nfr,10\nus,5\nfr,300\nus,42\nde,7\n")
+			subject := NewTsvIndexer(sc, HasHeader(), Separator(","), Fields("country", "revenue:int:desc"))
+			output := stringio.New()
+
+			check(subject.Analyze())
+			subject.Sort()
+			check(subject.Transfer(output))
+			Expect(output.GetValueString()).To(Equal("country,revenue\nde,7\nfr,300\nfr,10\nus,42\nus,5\n"))
+		})
+
+		It("sorts the strings case-insensitively", func() {
+			Expect(sortedValues([]string{"Zebra", "apple", "Banana"}, Fields("v:fold"))).To(
+				Equal([]string{"apple", "Banana", "Zebra"}))
+		})
+
+		It("sorts the normalized strings", func() {
+			Expect(sortedValues([]string{"fz", "\ufb01nal"}, Fields("v:nfkd"))).To(
+				Equal([]string{"\ufb01nal", "fz"}))
+		})
+
+		It("sorts the strings with the root collation", func() {
+			Expect(sortedValues([]string{"zèbre", "Zebra", "éclair", "apple", "Eclair", "côte", "cote"}, Fields("v:collate"))).To(
+				Equal([]string{"apple", "cote", "côte", "Eclair", "éclair", "Zebra", "zèbre"}))
+		})
+
+		It("sorts the strings with a locale collation", func() {
+			Expect(sortedValues([]string{"öl", "zebra", "ost"}, Fields("v:collate=sv"))).To(
+				Equal([]string{"ost", "zebra", "öl"}))
+			Expect(sortedValues([]string{"öl", "zebra", "ost"}, Fields("v:collate=en"))).To(
+				Equal([]string{"öl", "ost", "zebra"}))
+		})
+
+		It("sorts the collated strings in descending order", func() {
+			Expect(sortedValues([]string{"Zebra", "", "éclair", "apple"}, Fields("v:collate=fr:desc"))).To(
+				Equal([]string{"Zebra", "éclair", "apple", ""}))
+		})
+
+		It("rejects an invalid collation", func() {
+			subject := NewTsvIndexer(scanner("v\n1\n"), HasHeader(), Fields("v:collate=fr_FR!"))
+			Expect(subject.Analyze()).To(HaveOccurred())
+		})
+
+		It("rejects the collation of a typed key", func() {
+			subject := NewTsvIndexer(scanner("v\n1\n"), HasHeader(), SortKeys(SortKey{Field: "v", Type: IntKey, Collation: "fr"}))
+			Expect(subject.Analyze()).To(MatchError("sort key v: collation of a typed key"))
+		})
+
+		It("drops the empty descending values", func() {
+			Expect(sortedValues([]string{"a", "", "b"}, SortKeys(SortKey{Field: "v", Desc: true}), DropEmptyIndexedFields())).To(
+				Equal([]string{"b", "a"}))
+		})
+
+		It("drops the empty typed values", func() {
+			Expect(sortedValues([]string{"10", "", "9"}, Fields("v:int"), DropEmptyIndexedFields())).To(
+				Equal([]string{"9", "10"}))
+		})
+
+		It("returns the sort key errors from Analyze", func() {
+			subject := NewTsvIndexer(scanner("v\n1\n"), HasHeader(), Fields("v:int=42"))
+			Expect(subject.Analyze()).To(HaveOccurred())
+		})
+
+		It("merges the swapped lines in the typed order", func() {
+			var limit uint64 = 4200 << 20
+			var subject = NewTsvIndexer(scanner("n,v\na,10\nb,9\nc,100\nd,-1\ne,20\nf,3\n"), HasHeader(), Separator(","),
+				Fields("v:int"), SwapperOpts(limit, tempDir("", "tsv_swap_key")))
+			var output = stringio.New()
+
+			backupGetMemoryUsage := GetMemoryUsage
+			GetMemoryUsage = func() *HeapMemStat {
+				return &HeapMemStat{0, limit + 42, 0, 0, 0, 0}
+			}
+			defer func() { GetMemoryUsage = backupGetMemoryUsage }()
+
+			subject.Lines = make(TsvLines, 0, 2) // 2 lines per dump
+			check(subject.Analyze())
+			subject.Sort()
+			check(subject.Transfer(output))
+
+			Expect(output.GetValueString()).To(Equal("n,v\nd,-1\nf,3\nb,9\na,10\ne,20\nc,100\n"))
+		})
+
+		It("merges the swapped lines in the collation order", func() {
+			var limit uint64 = 4200 << 20
+			var subject = NewTsvIndexer(scanner("n,v\na,Zebra\nb,éclair\nc,apple\nd,Banana\ne,zèbre\nf,Eclair\n"), HasHeader(),
+				Separator(","), Fields("v:collate=fr"), SwapperOpts(limit, tempDir("", "tsv_swap_collate")))
+			var output = stringio.New()
+
+			backupGetMemoryUsage := GetMemoryUsage
+			GetMemoryUsage = func() *HeapMemStat {
+				return &HeapMemStat{0, limit + 42, 0, 0, 0, 0}
+			}
+			defer func() { GetMemoryUsage = backupGetMemoryUsage }()
+
+			subject.Lines = make(TsvLines, 0, 2) // 2 lines per dump
+			check(subject.Analyze())
+			subject.Sort()
+			check(subject.Transfer(output))
+
+			Expect(output.GetValueString()).To(Equal("n,v\nc,apple\nd,Banana\nf,Eclair\nb,éclair\na,Zebra\ne,zèbre\n"))
+		})
+
+		It("merges the swapped lines in descending order after the header", func() {
+			var limit uint64 = 4200 << 20
+			var subject = NewTsvIndexer(scanner("n,v\na,10\nb,9\nc,100\nd,-1\ne,20\nf,3\n"), HasHeader(), Separator(","),
+				Fields("v:int:desc"), SwapperOpts(limit, tempDir("", "tsv_swap_desc")))
+			var output = stringio.New()
+
+			backupGetMemoryUsage := GetMemoryUsage
+			GetMemoryUsage = func() *HeapMemStat {
+				return &HeapMemStat{0, limit + 42, 0, 0, 0, 0}
+			}
+			defer func() { GetMemoryUsage = backupGetMemoryUsage }()
+
+			subject.Lines = make(TsvLines, 0, 2) // 2 lines per dump
+			check(subject.Analyze())
+			subject.Sort()
+			check(subject.Transfer(output))
+
+			Expect(output.GetValueString()).To(Equal("n,v\nc,100\ne,20\na,10\nb,9\nf,3\nd,-1\n"))
+		})
+	})
+})
@@ -19,8 +19,10 @@ type (
 		Storage StorageService
 		// Chunksize is the number of elements per chunk within a dump.
 		ChunkSize func(nbOfElements int) int
-		dumps     []*dump
-		tsvLines  TsvLines // Used when no memory dump is needed
+		// Stable breaks the ties of the merged dumps on the offsets of the lines (the dumps must be sorted the same way).
+		Stable   bool
+		dumps    []*dump
+		tsvLines TsvLines // Used when no memory dump is needed
 	}
 
 	dump struct {
@@ -131,7 +133,11 @@ func (s *Swapper) ReadIterator() LineIterator {
 		// NullSwapper or Swapper without any dumps.
 		return newTsvLinesIterator(s.tsvLines)
 	}
-	return newDumpIterator(s.dumps, s.Storage)
+	less := CompareFunc
+	if s.Stable {
+		less = stableCompare
+	}
+	return newDumpIterator(s.dumps, s.Storage, less)
 }
 
 // EraseAll removes all stored data.
@@ -252,6 +258,7 @@ type (
 	dumpIterator struct {
 		current int
 		cit     []*chunkIterator
+		less    func(i, j TsvLine) bool
 	}
 )
 
@@ -280,7 +287,7 @@ func (it *tsvLinesIterator) Error() error {
 
 // ---
 
-func newDumpIterator(data []*dump, Storage StorageService) *dumpIterator {
+func newDumpIterator(data []*dump, Storage StorageService, less func(i, j TsvLine) bool) *dumpIterator {
 	cit := make([]*chunkIterator, len(data))
 	for i, d := range data {
 		cit[i] = newChunkIterator(d.chunks, Storage)
@@ -288,6 +295,7 @@ func newDumpIterator(data []*dump, Storage StorageService) *dumpIterator {
 	return &dumpIterator{
 		current: -42,
 		cit:     cit,
+		less:    less,
 	}
 }
 
@@ -343,7 +351,7 @@ func (it *dumpIterator) selectCurrent() {
 			continue
 		}
 
-		if CompareFunc(dump.Value(), it.cit[current].Value()) {
+		if it.less(dump.Value(), it.cit[current].Value()) {
 			current = i
 		}
 	}
@@ -2,17 +2,19 @@ package iosupport
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
-	"fmt"
 	"regexp"
 	"sort"
 	"strconv"
-	"strings"
 )
 
 // COMPARABLE_SEPARATOR defines the separator added between each indexed fields.
 const COMPARABLE_SEPARATOR = "\u0000"
 
+// ErrFooterInRecord -> the footer starts inside a record read across several lines (see SkipFooter option)
+var ErrFooterInRecord = errors.New("footer inside a record")
+
 // TsvLine describes the line's details from a TSV.
 type (
 	TsvLine struct {
@@ -22,6 +24,22 @@ type (
 	}
 	TsvLines []TsvLine
 
+	// A RowParser reads the rows of a file (e.g. TsvParser or FixedWidthParser), it is the row source of a TsvIndexer.
+	RowParser interface {
+		ScanRow() bool
+		Row() [][]byte
+		Err() error
+		Line() int
+		Offset() uint64
+		Limit() uint32
+		Reset()
+	}
+
+	// A row source that names its columns (e.g. FixedWidthParser).
+	headerParser interface {
+		Header() []string
+	}
+
 	// Internal use
 	seeker struct {
 		*Scanner
@@ -32,8 +50,17 @@ type (
 	TsvIndexer struct {
 		*Options
 		parser          *TsvParser
+		rows            RowParser // Row source, parser unless RowSource option is used.
+		rejects         *TsvWriter
+		key             []byte        // Reused buffer of the comparables.
+		Rejects         map[error]int // Number of skipped rows by cause (see OnRowError option).
+		Dialect         *Dialect      // Sniffed dialect (see SniffDialect option)
+		err             error         // Sort keys error returned by Analyze.
 		FieldsIndex     map[string]int
 		Lines           TsvLines
+		comments        TsvLines
+		preamble        TsvLines // Lines skipped by SkipLines.
+		footer          TsvLines // Lines skipped by SkipFooter and FooterPattern.
 		nbOfFields      int
 		seekers         []seeker
 		scannerFunc     func() *Scanner
@@ -48,25 +75,114 @@ func NewTsvIndexer(scannerFunc func() *Scanner, setters ...Option) *TsvIndexer {
 	sc.KeepNewlineSequence(true)
 
 	options := &Options{
-		Separator:     ',',
 		LineThreshold: 2500000,
 		Swapper:       NewNullSwapper(),
 	}
 	for _, setter := range setters {
 		setter(options)
 	}
+	options.Swapper.Stable = options.Stable
 
-	parser := NewTsvParser(sc, options.Separator)
+	sc.MaxLineLength(options.MaxLineLength)
+	sc.SkipLongLines(options.SkipLongLines)
+	if options.Delimiter != nil {
+		sc.SetDelimiter(options.Delimiter)
+	}
+
+	if len(options.SeparatorBytes) == 0 || options.SeparatorBytes[0] != options.Separator {
+		// The Separator attribute has been set without the Separator option
+		options.SeparatorBytes = nil
+		if options.Separator != 0 {
+			options.SeparatorBytes = []byte{options.Separator}
+		}
+	}
+
+	var err error
+	if options.Keys == nil {
+		options.Keys, err = parseSortKeys(options.Fields)
+	}
+	if err == nil {
+		options.Keys, err = compileSortKeys(options.Keys)
+	}
+
+	parser := NewTsvParser(sc, ',')
 	parser.LazyQuotes = options.LazyQuotes
-	return &TsvIndexer{
+	parser.Comment = options.Comment
+	parser.Escape = options.Escape
+	parser.ReuseRow = true // The keys are copied from the rows
+	ti := &TsvIndexer{
 		parser:          parser,
+		rows:            parser,
+		err:             err,
 		Options:         options,
 		FieldsIndex:     make(map[string]int),
+		Rejects:         make(map[error]int),
 		scannerFunc:     scannerFunc,
 		nbOfFields:      -1,
 		seekers:         []seeker{{sc, 0}},
-		blankComparable: strings.Repeat(COMPARABLE_SEPARATOR, len(options.Fields)),
+		blankComparable: blankComparable(options.Keys),
+	}
+	if options.KeepComments {
+		parser.onComment = ti.commentAppender
+	}
+	if options.SniffLines <= 0 {
+		ti.applyDialect(nil)
+	}
+	if options.RowSource != nil {
+		ti.rows = options.RowSource(sc)
+	}
+	ti.rejects = newRejectWriter(options.RejectFile)
+	return ti
+}
+
+// prepare skips the preamble and the footer of the TSV and sniffs its dialect, the Scanner is then ready to read the rows.
+func (ti *TsvIndexer) prepare() error {
+	sc := ti.parser.Scanner
+	preamble, err := skipPreamble(sc, ti.SkipLines)
+	if err != nil {
+		return err
+	}
+	footer, err := skipFooter(sc, ti.SkipFooter, ti.FooterPattern)
+	if err != nil {
+		return err
+	}
+	ti.preamble = preamble
+	ti.footer = footer
+
+	if ti.SniffLines <= 0 {
+		return nil
+	}
+	dialect, err := Sniff(sc, ti.SniffLines)
+	if err != nil {
+		return err
 	}
+	ti.applyDialect(dialect)
+	if dialect.SepLine {
+		return sc.SeekOffset(dialect.Offset) // The `sep=' line is dropped
+	}
+	return nil
+}
+
+// applyDialect completes the options with the sniffed dialect (nil without sniffing) and configures the parser.
+func (ti *TsvIndexer) applyDialect(dialect *Dialect) {
+	if dialect != nil {
+		if ti.SeparatorBytes == nil {
+			ti.SeparatorBytes = dialect.Separator()
+		}
+		if !ti.headerGiven {
+			ti.Header = dialect.Header
+		}
+		if dialect.QuoteChar != 0 {
+			ti.parser.QuoteChar = dialect.QuoteChar
+		}
+		ti.Dialect = dialect
+	}
+	if ti.SeparatorBytes == nil {
+		ti.SeparatorBytes = []byte{','}
+	}
+	ti.Separator = ti.SeparatorBytes[0]
+	ti.parser.SetSeparator(ti.SeparatorBytes)
+	ti.parser.SyncConfig()
 }
 
 // CloseIO closes all opened IO.
@@ -76,50 +192,130 @@ func (ti *TsvIndexer) CloseIO() {
 }
 
 // Analyze parses the TSV and generates the indexes.
+// The malformed rows are handled according to the error policy (see OnRowError option).
 func (ti *TsvIndexer) Analyze() error {
-	if !ti.Header {
-		// Validate provided Fields with the generated header.
+	if ti.err != nil {
+		return ti.err
+	}
+	if err := ti.prepare(); err != nil {
+		return err
+	}
+	if names := ti.columnNames(); names != nil && !ti.Header {
+		// The row source names its columns
+		if err := ti.findFieldsIndex(names); err != nil {
+			return err
+		}
+	} else if !ti.Header {
+		// Without header, fields are named like the following pattern /var\d+/
+		// \d+ is used for the index of the variable
+		//
+		// e.g. `var1,var2,var3` with `var1` had the index 0
 		re := regexp.MustCompile(`var(\d+)`)
-		for _, field := range ti.Fields {
-			if len(re.FindStringSubmatch(field)) < 2 {
-				return errors.New("Field " + field + " do not match with pattern /var\\d+/")
+		for _, key := range ti.Keys {
+			match := re.FindStringSubmatch(key.Field)
+			if len(match) < 2 {
+				return errors.New("Field " + key.Field + " do not match with pattern /var\\d+/")
+			}
+			i, err := strconv.Atoi(match[1])
+			if err != nil {
+				return err
 			}
+			ti.FieldsIndex[key.Field] = i - 1
 		}
 	}
-	for ti.parser.ScanRow() {
-		if ti.parser.Err() != nil {
-			return ti.parser.Err()
+	defer ti.rejects.Flush()
+
+	nrow := 0
+	for ti.rows.ScanRow() {
+		if len(ti.footer) > 0 && ti.rows == RowParser(ti.parser) && ti.parser.unterminated {
+			return ErrFooterInRecord // The footer lines are a part of the last record
+		}
+		if err := ti.rows.Err(); err != nil {
+			if ok, err := ti.handleParseError(err, nrow); !ok {
+				if err != nil {
+					return err
+				}
+				continue
+			}
 		}
-		err := ti.tsvLineAppender(ti.parser.Row(), len(ti.Lines), ti.parser.Line(), ti.parser.Offset(), ti.parser.Limit())
+
+		nrow++
+		err := ti.tsvLineAppender(ti.rows.Row(), len(ti.Lines), nrow, ti.rows.Offset(), ti.rows.Limit())
 		if err != nil {
 			return err
 		}
 
 		ti.tryToSwap(false)
 	}
+	if err := ti.rows.Err(); err != nil {
+		return err
+	}
 	ti.tryToSwap(true)
-	ti.parser.Reset()
+	ti.rows.Reset()
 	ti.createSeekers()
 	return nil
 }
 
 // Sort sorts TsvLine on its comparables.
+// With the Stable option, the lines with equal comparables keep the order of the TSV.
 func (ti *TsvIndexer) Sort() {
+	if ti.Stable {
+		sort.Sort(stableTsvLines(ti.Lines))
+		return
+	}
 	sort.Sort(ti.Lines)
 }
 
 // Transfer writes sorted TSV into a new file.
 func (ti *TsvIndexer) Transfer(output FileWriter) error {
 	w := bufio.NewWriter(output)
-	ns := ti.parser.NewlineSequence()
-	n := ns[len(ns)-1]
+	ns := ti.parser.encode(ti.parser.NewlineSequence()) // In the encoding of the TSV
+	n := ns // End of a terminated line
+	if ti.parser.delim == nil {
+		n = ns[len(ns)-ti.parser.nlLen:]
+	}
+
+	// Keep the byte order mark of the TSV
+	if _, err := w.Write(ti.parser.BOM()); err != nil {
+		return err
+	}
+
+	// Kept preamble and comments are written at the top of the output
+	if ti.KeepPreamble {
+		if err := ti.transferLines(w, newTsvLinesIterator(ti.preamble), ns, n); err != nil {
+			return err
+		}
+	}
+	if err := ti.transferLines(w, newTsvLinesIterator(ti.comments), ns, n); err != nil {
+		return err
+	}
 
 	// For all sorted lines contained in the TSV
 	it := ti.Swapper.ReadIterator()
 	if it.Error() != nil {
 		return it.Error()
 	}
+	if err := ti.transferLines(w, it, ns, n); err != nil {
+		return err
+	}
+
+	// Kept footer is written at the bottom of the output
+	if ti.KeepFooter {
+		if err := ti.transferLines(w, newTsvLinesIterator(ti.footer), ns, n); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	ti.releaseSeekers()
+	ti.Swapper.EraseAll()
+	return nil
+}
 
+// transferLines writes all the lines of the given iterator.
+func (ti *TsvIndexer) transferLines(w *bufio.Writer, it LineIterator, ns, n []byte) error {
 	for it.Next() {
 		if it.Error() != nil {
 			return it.Error()
@@ -131,7 +327,7 @@ func (ti *TsvIndexer) Transfer(output FileWriter) error {
 			return err
 		}
 
-		if token[len(token)-1] != n {
+		if !bytes.HasSuffix(token, n) {
 			token = append(token, ns...) // Appends newline sequence when missing
 		}
 
@@ -139,11 +335,6 @@ func (ti *TsvIndexer) Transfer(output FileWriter) error {
 			return err
 		}
 	}
-	if err := w.Flush(); err != nil {
-		return err
-	}
-	ti.releaseSeekers()
-	ti.Swapper.EraseAll()
 	return nil
 }
 
@@ -167,6 +358,32 @@ var CompareFunc = func(i, j TsvLine) bool {
 	return i.Comparable < j.Comparable
 }
 
+// stableTsvLines sorts the lines with equal comparables on their offsets.
+type stableTsvLines TsvLines
+
+func (slice stableTsvLines) Len() int {
+	return len(slice)
+}
+
+func (slice stableTsvLines) Less(i, j int) bool {
+	return stableCompare(slice[i], slice[j])
+}
+
+func (slice stableTsvLines) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// stableCompare compares the lines with CompareFunc and breaks the ties on their offsets.
+func stableCompare(i, j TsvLine) bool {
+	if CompareFunc(i, j) {
+		return true
+	}
+	if CompareFunc(j, i) {
+		return false
+	}
+	return i.Offset < j.Offset
+}
+
 // ------------------ //
 // Transfer stuff     //
 // ------------------ //
@@ -221,53 +438,106 @@ func (ti *TsvIndexer) selectSeeker(offset uint64) seeker {
 // Analyze stuff      //
 // ------------------ //
 
-func (ti *TsvIndexer) tsvLineAppender(row [][]byte, index int, fileline int, offset uint64, limit uint32) error {
-	if !ti.isValidRow(row) {
+// nrow is the index of the row in the TSV (comment lines and rows skipped on parse errors are not counted).
+func (ti *TsvIndexer) tsvLineAppender(row [][]byte, index int, nrow int, offset uint64, limit uint32) error {
+	if ok, err := ti.checkRow(row); !ok {
 		// Discard mal-formatted lines
-		return nil
+		return err
 	}
 
 	ti.Lines = append(ti.Lines, TsvLine{"", offset, limit})
-	if fileline == 1 && ti.Header {
-		if err := ti.findFieldsIndex(row); err != nil {
+	if nrow == 1 && ti.Header {
+		if err := ti.findFieldsIndex(toStrings(row)); err != nil {
 			return err
 		}
 		// Build empty comparable
 		// When comparables are sorted, this one (the header) remains the first line
-		for i := 0; i < len(ti.Fields); i++ {
-			ti.Lines[index].Comparable = ""
-		}
-		ti.nbOfFields = len(row)
-	} else if fileline == 1 {
-		// Without header, fields are named like the following pattern /var\d+/
-		// \d+ is used for the index of the variable
-		//
-		// e.g. `var1,var2,var3` with `var1` had the index 0
-		re := regexp.MustCompile(`var(\d+)`)
-		for _, field := range ti.Fields {
-			i, err := strconv.Atoi(re.FindStringSubmatch(field)[1])
-			if err != nil {
-				return err
+		ti.Lines[index].Comparable = ""
+	} else {
+		key := ti.key[:0]
+		for k := range ti.Keys {
+			var value []byte // Missing key column of a kept row is empty
+			if i := ti.FieldsIndex[ti.Keys[k].Field]; i < len(row) {
+				value = row[i]
 			}
-			ti.FieldsIndex[field] = i - 1
-			ti.appendComparable(row[i-1], index) // The first row contains data (/!\ it is not an header)
+			key = ti.Keys[k].appendKey(key, value)
+			key = append(key, COMPARABLE_SEPARATOR...)
 		}
+		ti.key = key
+		ti.Lines[index].Comparable = string(key) // Only the key is copied (the row is reused by the parser)
 		ti.dropLastLineIfEmptyComparable()
+	}
+	if ti.nbOfFields == -1 {
 		ti.nbOfFields = len(row)
-	} else {
-		for _, field := range ti.Fields {
-			ti.appendComparable(row[ti.FieldsIndex[field]], index)
-		}
-		ti.dropLastLineIfEmptyComparable()
 	}
 	return nil
 }
 
-// It concats the given comparable to the existing comparable
-func (ti *TsvIndexer) appendComparable(comparable []byte, index int) {
-	cp := make([]byte, len(comparable), len(comparable))
-	copy(cp, comparable) // Freeing the underlying array (https://blog.golang.org/go-slices-usage-and-internals - chapter: A possible "gotcha")
-	ti.Lines[index].Comparable += fmt.Sprintf("%s%s", cp, COMPARABLE_SEPARATOR)
+// skipPreamble moves the Scanner after the n first lines and returns these lines.
+func skipPreamble(sc *Scanner, n int) (TsvLines, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	sc.Reset()
+	preamble := TsvLines{}
+	for len(preamble) < n && sc.ScanLine() {
+		preamble = append(preamble, TsvLine{"", sc.Offset(), sc.Limit()})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return preamble, sc.SeekOffset(sc.Offset() + uint64(sc.Limit()))
+}
+
+// skipFooter stops the Scanner before the n last lines and the trailing lines that match the pattern.
+// It returns these lines, the first line read by the Scanner is never a part of the footer.
+// The lines are read by the Scanner, so its delimiter, its encoding and the long lines policy are honored.
+func skipFooter(sc *Scanner, n int, pattern *regexp.Regexp) (TsvLines, error) {
+	if n <= 0 && pattern == nil {
+		return nil, nil
+	}
+	if n < 0 {
+		n = 0
+	}
+
+	type footerLine struct {
+		TsvLine
+		match bool
+	}
+	footer := []footerLine{} // The lines that may be a part of the footer
+
+	sc.Reset()
+	for first := true; sc.ScanLine(); first = false {
+		if first {
+			continue
+		}
+		match := pattern != nil && pattern.Match(sc.trimDelimiter(sc.Bytes()))
+		footer = append(footer, footerLine{TsvLine{"", sc.Offset(), sc.Limit()}, match})
+
+		// A line that precedes the n last ones is a part of the footer when it and the following ones match the pattern
+		if i := len(footer) - n - 1; i >= 0 && !footer[i].match {
+			footer = footer[i+1:]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	lines := make(TsvLines, len(footer))
+	for i, line := range footer {
+		lines[i] = line.TsvLine
+	}
+	if len(lines) > 0 {
+		sc.StopAt(lines[0].Offset)
+	}
+	sc.Reset()
+	return lines, nil
+}
+
+// commentAppender keeps track of the skipped comment lines.
+func (ti *TsvIndexer) commentAppender(offset uint64, limit uint32) {
+	ti.comments = append(ti.comments, TsvLine{"", offset, limit})
 }
 
 func (ti *TsvIndexer) dropLastLineIfEmptyComparable() {
@@ -281,24 +551,100 @@ func (ti *TsvIndexer) dropLastLineIfEmptyComparable() {
 	}
 }
 
-// Append to TsvIndexer.FieldsIndex the index in the row of all TsvIndexer.Fields
-func (ti *TsvIndexer) findFieldsIndex(row [][]byte) error {
+// columnNames returns the column names given by the row source (nil when the columns are not named).
+func (ti *TsvIndexer) columnNames() []string {
+	if rows, ok := ti.rows.(headerParser); ok {
+		return rows.Header()
+	}
+	return nil
+}
+
+// Append to TsvIndexer.FieldsIndex the index in the row of all the fields of TsvIndexer.Keys
+func (ti *TsvIndexer) findFieldsIndex(row []string) error {
 	for i, head := range row {
-		for _, field := range ti.Fields {
-			if string(head) == field {
-				ti.FieldsIndex[field] = i
-				break
+		for _, key := range ti.Keys {
+			if head == key.Field {
+				ti.FieldsIndex[key.Field] = i
 			}
 		}
 	}
-	if len(ti.Fields) != len(ti.FieldsIndex) {
-		return errors.New("Invalid separator or sort fields")
+	for _, key := range ti.Keys {
+		if _, ok := ti.FieldsIndex[key.Field]; !ok {
+			return errors.New("Invalid separator or sort fields")
+		}
 	}
 	return nil
 }
 
-func (ti *TsvIndexer) isValidRow(row [][]byte) bool {
-	return !ti.SkipMalformattedLines || ti.nbOfFields == len(row) || ti.nbOfFields == -1
+// blankComparable returns the comparable of a line where all the keys are empty.
+func blankComparable(keys []SortKey) string {
+	var key []byte
+	for k := range keys {
+		key = keys[k].appendKey(key, nil)
+		key = append(key, COMPARABLE_SEPARATOR...)
+	}
+	return string(key)
+}
+
+// parseSortKeys parses the fields of the Fields option.
+func parseSortKeys(fields []string) ([]SortKey, error) {
+	keys := make([]SortKey, len(fields))
+	for i, field := range fields {
+		key, err := ParseSortKey(field)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// compileSortKeys returns a copy of the keys ready to encode the values (see SortKey.compile).
+func compileSortKeys(keys []SortKey) ([]SortKey, error) {
+	compiled := make([]SortKey, len(keys))
+	for i := range keys {
+		compiled[i] = keys[i]
+		if err := compiled[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
+
+func toStrings(row [][]byte) []string {
+	names := make([]string, len(row))
+	for i, field := range row {
+		names[i] = string(field)
+	}
+	return names
+}
+
+// checkRow applies the error policy on a row with a wrong number of fields or without all the key columns.
+// It returns whether the row is indexed.
+func (ti *TsvIndexer) checkRow(row [][]byte) (bool, error) {
+	if ti.nbOfFields != -1 && ti.nbOfFields != len(row) {
+		fallback := KeepRow
+		if ti.SkipMalformattedLines {
+			fallback = SkipRow
+		}
+		column := len(row)
+		if column > ti.nbOfFields {
+			column = ti.nbOfFields
+		}
+		if ok, err := ti.handleRowError(column, ErrFieldCount, fallback); !ok {
+			return false, err
+		}
+	}
+
+	if ti.nbOfFields == -1 && ti.Header {
+		return true, nil // Header row
+	}
+	for _, key := range ti.Keys {
+		if i := ti.FieldsIndex[key.Field]; i >= len(row) {
+			return ti.handleRowError(i, ErrMissingKeyColumn, AbortRow)
+		}
+	}
+	return true, nil
 }
 
 func (ti *TsvIndexer) tryToSwap(force bool) error {
@@ -1,15 +1,36 @@
 package iosupport
 
+import "regexp"
+
 // Options contains information for TSV interations.
 type Options struct {
 	Header                 bool
 	Separator              byte
+	SeparatorBytes         []byte // Separator made of several bytes, its first byte is Separator.
 	Fields                 []string
+	Keys                   []SortKey
 	DropEmptyIndexedFields bool
 	SkipMalformattedLines  bool
 	LineThreshold          int
 	Swapper                *Swapper
 	LazyQuotes             bool
+	Comment                byte
+	KeepComments           bool
+	MaxLineLength          int
+	SkipLongLines          bool
+	Delimiter              Delimiter
+	Escape                 byte
+	SniffLines             int
+	RowSource              func(sc *Scanner) RowParser
+	OnRowError             func(*RowError) Action
+	RejectFile             FileWriter
+	SkipLines              int
+	SkipFooter             int
+	FooterPattern          *regexp.Regexp
+	KeepPreamble           bool
+	KeepFooter             bool
+	Stable                 bool
+	headerGiven            bool // Header has been given by an option (see SniffDialect)
 }
 
 // Option is a function used in the Functional Options pattern.
@@ -19,6 +40,7 @@ type Option func(*Options)
 func Header(header bool) Option {
 	return func(opts *Options) {
 		opts.Header = header
+		opts.headerGiven = true
 	}
 }
 
@@ -26,23 +48,41 @@ func Header(header bool) Option {
 func HasHeader() Option {
 	return func(opts *Options) {
 		opts.Header = true
+		opts.headerGiven = true
 	}
 }
 
-// Separator of the TSV.
+// Separator of the TSV, it may be made of several bytes (e.g. `||', `¦' or `\u0001\u0002').
+// An empty separator is ignored.
 func Separator(separator string) Option {
 	return func(opts *Options) {
-		opts.Separator = UnescapeSeparator(separator)
+		if separator := UnescapeSeparatorBytes(separator); len(separator) > 0 {
+			opts.SeparatorBytes = separator
+			opts.Separator = separator[0]
+		}
 	}
 }
 
 // Fields on which the TSV can be sorted.
+// A field may define how its values are compared and its direction (e.g. `revenue:int:desc' or `date:time=02/01/2006', see ParseSortKey).
 func Fields(fields ...string) Option {
 	return func(opts *Options) {
 		opts.Fields = fields
 	}
 }
 
+// SortKeys defines the fields on which the TSV can be sorted and how their values are compared.
+// It replaces the Fields option.
+func SortKeys(keys ...SortKey) Option {
+	return func(opts *Options) {
+		opts.Keys = keys
+		opts.Fields = make([]string, len(keys))
+		for i, key := range keys {
+			opts.Fields[i] = key.Field
+		}
+	}
+}
+
 // DropEmptyIndexedFields removes the lines where the comparable is empty.
 func DropEmptyIndexedFields() Option {
 	return func(opts *Options) {
@@ -50,7 +90,7 @@ func DropEmptyIndexedFields() Option {
 	}
 }
 
-// SkipMalformattedLines ignores mal-formatted lines.
+// SkipMalformattedLines ignores mal-formatted lines (the lines with a wrong number of fields).
 func SkipMalformattedLines() Option {
 	return func(opts *Options) {
 		opts.SkipMalformattedLines = true
@@ -80,3 +120,151 @@ func LazyQuotesMode() Option {
 		opts.LazyQuotes = true
 	}
 }
+
+// CommentChar defines the character used to start a comment line, an empty string disables the comment lines.
+// Comment lines are not indexed and they are dropped from the Transfer output.
+func CommentChar(comment string) Option {
+	return func(opts *Options) {
+		opts.Comment = 0
+		if comment != "" {
+			opts.Comment = comment[0]
+		}
+	}
+}
+
+// KeepComments writes the comment lines at the top of the Transfer output.
+func KeepComments() Option {
+	return func(opts *Options) {
+		opts.KeepComments = true
+	}
+}
+
+// MaxLineLength defines the maximum length of a line (see Scanner.MaxLineLength).
+// Analyze fails on longer lines unless SkipLongLines is used.
+func MaxLineLength(length int) Option {
+	return func(opts *Options) {
+		opts.MaxLineLength = length
+	}
+}
+
+// SkipLongLines ignores the lines longer than the maximum line length.
+func SkipLongLines() Option {
+	return func(opts *Options) {
+		opts.SkipLongLines = true
+	}
+}
+
+// LineDelimiter defines the sequence of bytes that terminates the lines of the TSV (see Scanner.SetDelimiter).
+// An empty delimiter keeps any newline sequence.
+func LineDelimiter(delim Delimiter) Option {
+	return func(opts *Options) {
+		opts.Delimiter = nil
+		if len(delim) > 0 {
+			opts.Delimiter = delim
+		}
+	}
+}
+
+// EscapeChar defines the escape character (e.g. `\') of PostgreSQL or MySQL text dumps, it replaces RFC 4180 quoting.
+// An empty string keeps RFC 4180 quoting.
+func EscapeChar(escape string) Option {
+	return func(opts *Options) {
+		opts.Escape = 0
+		if escape != "" {
+			opts.Escape = escape[0]
+		}
+	}
+}
+
+// SniffDialect guesses the separator, the quote char and the header presence from the sampleLines first lines
+// of the TSV (see Sniff). The Separator and Header options take precedence over the sniffed ones.
+// The dialect is sniffed by Analyze.
+func SniffDialect(sampleLines int) Option {
+	return func(opts *Options) {
+		opts.SniffLines = sampleLines
+	}
+}
+
+// RowSource replaces the TsvParser by the row parser returned by the given function (e.g. a FixedWidthParser).
+// The function is called with the Scanner of the TsvIndexer. When the columns are named by the row parser,
+// the Fields are found by these names.
+func RowSource(fn func(sc *Scanner) RowParser) Option {
+	return func(opts *Options) {
+		opts.RowSource = fn
+	}
+}
+
+// OnRowError defines the error policy of the malformed rows (parse errors, wrong number of fields and missing key columns).
+// By default, the parse errors and the missing key columns abort Analyze and the wrong numbers of fields are kept
+// (or skipped with SkipMalformattedLines).
+func OnRowError(fn func(*RowError) Action) Option {
+	return func(opts *Options) {
+		opts.OnRowError = fn
+	}
+}
+
+// RejectFile writes the skipped rows into the given file, one TSV row per rejected row:
+// its line, its offset, the reason and the raw row. The fields are always separated by a tab
+// (and quoted like RFC 4180 when needed) whatever the separator of the indexed TSV.
+func RejectFile(w FileWriter) Option {
+	return func(opts *Options) {
+		opts.RejectFile = w
+	}
+}
+
+// SkipLines ignores the n first lines of the TSV (e.g. the title lines of a report), the header or the first row is on the next line.
+// The lines of the rows are then counted from the first line after the skipped ones (see RowError).
+func SkipLines(n int) Option {
+	return func(opts *Options) {
+		opts.SkipLines = n
+	}
+}
+
+// HeaderLine reads the header from the given line (the first line is 1), the preceding lines are skipped (see SkipLines).
+func HeaderLine(line int) Option {
+	return func(opts *Options) {
+		opts.SkipLines = line - 1
+		opts.Header = true
+		opts.headerGiven = true
+	}
+}
+
+// SkipFooter ignores the n last lines of the TSV (e.g. a totals footer).
+// The footer is found on the lines read like the rows (see LineDelimiter and the encoding of the Scanner),
+// the TSV is read once more for that by Analyze. It never includes the header or the first row and Analyze fails with
+// ErrFooterInRecord when it starts inside a record read across several lines (e.g. a quoted field).
+func SkipFooter(n int) Option {
+	return func(opts *Options) {
+		opts.SkipFooter = n
+	}
+}
+
+// FooterPattern ignores the trailing lines that match the given pattern (e.g. `^(Total|Generated on)`).
+// Combined with SkipFooter, the matching lines that precede the n last lines are also ignored.
+func FooterPattern(pattern *regexp.Regexp) Option {
+	return func(opts *Options) {
+		opts.FooterPattern = pattern
+	}
+}
+
+// KeepPreamble writes the lines skipped by SkipLines or HeaderLine at the top of the Transfer output.
+func KeepPreamble() Option {
+	return func(opts *Options) {
+		opts.KeepPreamble = true
+	}
+}
+
+// KeepFooter writes the lines skipped by SkipFooter or FooterPattern at the bottom of the Transfer output.
+func KeepFooter() Option {
+	return func(opts *Options) {
+		opts.KeepFooter = true
+	}
+}
+
+// Stable keeps the order of the TSV for the lines with equal sort keys, in memory and across the swapped dumps.
+// The ties are broken on the offsets of the lines, so repeated sorts give the same output.
+func Stable() Option {
+	return func(opts *Options) {
+		opts.Stable = true
+	}
+}
@@ -1,6 +1,10 @@
 package iosupport_test
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
+
 	. "github.com/mdouchement/iosupport"
 	"github.com/mdouchement/stringio"
 
@@ -8,6 +12,17 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+// readCountingFile counts the calls to Read.
+type readCountingFile struct {
+	*stringio.StringIO
+	reads int
+}
+
+func (f *readCountingFile) Read(b []byte) (int, error) {
+	f.reads++
+	return f.StringIO.Read(b)
+}
+
 var _ = Describe("TsvIndexer", func() {
 	Describe("#Analyze", func() {
 		Context("with a well formatted TSV", func() {
@@ -106,6 +121,20 @@ var _ = Describe("TsvIndexer", func() {
 			})
 		})
 
+		Context("with the Stable option", func() {
+			var input, expected = stableTsv(100)
+			var subject = NewTsvIndexer(scanner(input), HasHeader(), Separator(","), Fields("k"), Stable())
+			var output = stringio.New()
+
+			check(subject.Analyze())
+			subject.Sort()
+			check(subject.Transfer(output))
+
+			It("keeps the order of the TSV for the equal keys", func() {
+				Expect(output.GetValueString()).To(Equal(expected))
+			})
+		})
+
 		Context("when the file is empty", func() {
 			var sc = scanner("")
 			var subject = NewTsvIndexer(sc, HasHeader(), Separator(","), Fields("c1", "c4"))
@@ -165,6 +194,373 @@ var _ = Describe("TsvIndexer", func() {
 		})
 	})
 
+	Describe("with quoted fields containing newlines", func() {
+		var sc = scanner("c1,c2\n\"b\nb\",2\n\"a\r\na\",1\nc,3\n")
+		var subject = NewTsvIndexer(sc, HasHeader(), Separator(","), Fields("c1"))
+		var output = stringio.New()
+
+		err := subject.Analyze()
+		check(err)
+		subject.Sort()
+		err = subject.Transfer(output)
+		check(err)
+
+		It("sorts and transfers the whole records", func() {
+			Expect(output.GetValueString()).To(Equal("c1,c2\n\"a\r\na\",1\n\"b\nb\",2\nc,3\n"))
+		})
+	})
+
+	Describe("with comment lines", func() {
+		var data = "# comment 1\nc1,c2\nb,2\n# comment 2\na,1\n"
+
+		Context("when comments are dropped", func() {
+			var subject = NewTsvIndexer(scanner(data), HasHeader(), Separator(","), Fields("c1"), CommentChar("#"))
+			var output = stringio.New()
+
+			err := subject.Analyze()
+			check(err)
+			subject.Sort()
+			err = subject.Transfer(output)
+			check(err)
+
+			It("does not index the comments", func() {
+				Expect(subject.Lines).To(TlConsistOf(tl{"", 12, 6}, tl{cs("a"), 34, 4}, tl{cs("b"), 18, 4}))
+			})
+
+			It("removes the comments from the output", func() {
+				Expect(output.GetValueString()).To(Equal("c1,c2\na,1\nb,2\n"))
+			})
+		})
+
+		Context("when comments are kept", func() {
+			var subject = NewTsvIndexer(scanner(data), HasHeader(), Separator(","), Fields("c1"), CommentChar("#"), KeepComments())
+			var output = stringio.New()
+
+			err := subject.Analyze()
+			check(err)
+			subject.Sort()
+			err = subject.Transfer(output)
+			check(err)
+
+			It("writes the comments at the top of the output", func() {
+				Expect(output.GetValueString()).To(Equal("# comment 1\n# comment 2\nc1,c2\na,1\nb,2\n"))
+			})
+		})
+
+		Context("when the comment char is empty", func() {
+			var subject = NewTsvIndexer(scanner("c1,c2\nb,2\n#,3\n"), HasHeader(), Separator(","), Fields("c1"), CommentChar(""))
+
+			err := subject.Analyze()
+			check(err)
+
+			It("indexes all the lines", func() {
+				Expect(subject.Lines).To(TlConsistOf(tl{"", 0, 6}, tl{cs("b"), 6, 4}, tl{cs("#"), 10, 4}))
+			})
+		})
+	})
+
+	Describe("with long lines", func() {
+		var data = "c1,c2\nb,2\n\"aaaaaaaaaa\naaaaaaaaaa\",3\naaaaaaaaaaaaaaaaaaaa,4\na,1\n"
+
+		Context("when long lines are skipped", func() {
+			var subject = NewTsvIndexer(scanner(data), HasHeader(), Separator(","), Fields("c1"), MaxLineLength(16), SkipLongLines())
+			var output = stringio.New()
+
+			err := subject.Analyze()
+			check(err)
+			subject.Sort()
+			err = subject.Transfer(output)
+			check(err)
+
+			It("removes the long lines and records from the output", func() {
+				Expect(output.GetValueString()).To(Equal("c1,c2\na,1\nb,2\n"))
+			})
+		})
+
+		Context("when long lines are not skipped", func() {
+			var subject = NewTsvIndexer(scanner(data), HasHeader(), Separator(","), Fields("c1"), MaxLineLength(16))
+
+			err := subject.Analyze()
+
+			It("returns an error", func() {
+				Expect(err).To(Equal(&LineTooLongError{Line: 3, Offset: 10}))
+			})
+		})
+	})
+
+	Describe("with a line delimiter", func() {
+		var sc = scanner("c1,c2\r\nb,2\r\na,1\rx\r\n\"c\r\nc\",3\r\n")
+		var subject = NewTsvIndexer(sc, HasHeader(), Separator(","), Fields("c1"), LineDelimiter(CRLFDelimiter))
+		var output = stringio.New()
+
+		err := subject.Analyze()
+		check(err)
+		subject.Sort()
+		err = subject.Transfer(output)
+		check(err)
+
+		It("keeps the stray CR in the fields", func() {
+			Expect(output.GetValueString()).To(Equal("c1,c2\r\na,1\rx\r\nb,2\r\n\"c\r\nc\",3\r\n"))
+		})
+
+		Context("when it is empty", func() {
+			var subject = NewTsvIndexer(scanner("c1,c2\nb,2\na,1\n"), HasHeader(), Separator(","), Fields("c1"), LineDelimiter(Delimiter{}))
+
+			err := subject.Analyze()
+			check(err)
+
+			It("indexes the lines terminated by any newline sequence", func() {
+				Expect(subject.Delimiter).To(BeNil())
+				Expect(subject.Lines).To(TlConsistOf(tl{"", 0, 6}, tl{cs("b"), 6, 4}, tl{cs("a"), 10, 4}))
+			})
+		})
+	})
+
+	Describe("with a separator made of several bytes", func() {
+		var sc = scanner("c1||c2\nb||2\n\"a||\"||1\n")
+		var subject = NewTsvIndexer(sc, HasHeader(), Separator("||"), Fields("c2"))
+		var output = stringio.New()
+
+		err := subject.Analyze()
+		check(err)
+		subject.Sort()
+		err = subject.Transfer(output)
+		check(err)
+
+		It("sorts the TSV on the given field", func() {
+			Expect(output.GetValueString()).To(Equal("c1||c2\n\"a||\"||1\nb||2\n"))
+		})
+
+		It("keeps the first byte in the Separator attribute", func() {
+			Expect(subject.Separator).To(Equal(byte('|')))
+			Expect(subject.SeparatorBytes).To(Equal([]byte("||")))
+		})
+
+		It("ignores an empty separator", func() {
+			subject := NewTsvIndexer(scanner("a,b\n"), Separator(""), Fields("var1"))
+			Expect(subject.SeparatorBytes).To(Equal([]byte(",")))
+		})
+	})
+
+	Describe("with the escape character dialect", func() {
+		var sc = scanner("b\\tb\t2\n\"a\t\\N\nc\\\nc\t3\n")
+		var subject = NewTsvIndexer(sc, Separator("\\t"), Fields("var1", "var2"), EscapeChar("\\"))
+		var output = stringio.New()
+
+		err := subject.Analyze()
+		check(err)
+		subject.Sort()
+		err = subject.Transfer(output)
+		check(err)
+
+		It("indexes the unescaped fields", func() {
+			Expect(subject.Lines).To(TlConsistOf(tl{cs(`"a`, ""), 7, 6}, tl{cs("b\tb", "2"), 0, 7}, tl{cs("c\nc", "3"), 13, 7}))
+		})
+
+		It("transfers the escaped lines", func() {
+			Expect(output.GetValueString()).To(Equal("\"a\t\\N\nb\\tb\t2\nc\\\nc\t3\n"))
+		})
+
+		Context("when the escape char is empty", func() {
+			var subject = NewTsvIndexer(scanner("\"a\\\",1\nb,2\n"), Separator(","), Fields("var1"), EscapeChar(""))
+
+			err := subject.Analyze()
+			check(err)
+
+			It("keeps RFC 4180 quoting", func() {
+				Expect(subject.Escape).To(BeZero())
+				Expect(subject.Lines).To(TlConsistOf(tl{cs("a\\"), 0, 7}, tl{cs("b"), 7, 4}))
+			})
+		})
+	})
+
+	Describe("with a sniffed dialect", func() {
+		Context("when separator and header are omitted", func() {
+			var sc = scanner("sep=;\nname;age\nBob;7\nAlice;42\n")
+			var subject = NewTsvIndexer(sc, Fields("name"), SniffDialect(10))
+			var output = stringio.New()
+
+			err := subject.Analyze()
+			check(err)
+			subject.Sort()
+			err = subject.Transfer(output)
+			check(err)
+
+			It("uses the sniffed dialect", func() {
+				Expect(subject.Dialect).ToNot(BeNil())
+				Expect(subject.Header).To(BeTrue())
+				Expect(subject.Separator).To(Equal(byte(';')))
+				Expect(subject.SeparatorBytes).To(Equal([]byte(";")))
+			})
+
+			It("sorts the TSV without the sep line", func() {
+				Expect(output.GetValueString()).To(Equal("name;age\nAlice;42\nBob;7\n"))
+			})
+		})
+
+		Context("when separator and header are given", func() {
+			var sc = scanner("a;b\n1;2\n")
+			var subject = NewTsvIndexer(sc, Header(false), Separator(","), Fields("var1"), SniffDialect(10))
+
+			err := subject.Analyze()
+			check(err)
+
+			It("keeps the given options", func() {
+				Expect(subject.Header).To(BeFalse())
+				Expect(subject.Separator).To(Equal(byte(',')))
+				Expect(subject.Lines).To(TlConsistOf(tl{cs("a;b"), 0, 4}, tl{cs("1;2"), 4, 4}))
+			})
+		})
+	})
+
+	Describe("with a fixed-width row source", func() {
+		var sc = scanner("B0002  1337\nA0001     42rent\nC3\n")
+		var subject = NewTsvIndexer(sc, Fields("account"), RowSource(func(sc *Scanner) RowParser {
+			return NewFixedWidthParser(sc,
+				FixedWidthColumn{Name: "account", Start: 0, End: 6},
+				FixedWidthColumn{Name: "amount", Start: 6, End: 12},
+				FixedWidthColumn{Name: "label", Start: 12})
+		}))
+		var output = stringio.New()
+
+		err := subject.Analyze()
+		check(err)
+
+		It("indexes the lines with the column names", func() {
+			Expect(subject.Lines).To(TlConsistOf(tl{cs("B0002"), 0, 12}, tl{cs("A0001"), 12, 17}, tl{cs("C3"), 29, 3}))
+		})
+
+		It("sorts the file", func() {
+			subject.Sort()
+			check(subject.Transfer(output))
+			Expect(output.GetValueString()).To(Equal("A0001     42rent\nB0002  1337\nC3\n"))
+		})
+	})
+
+	Describe("with a preamble and a footer", func() {
+		var data = "Sales report\nQ3\nc1,c2\nb,2\na,1\n\nTotal,3\nGenerated on 2016-10-01\n"
+
+		Context("when they are dropped", func() {
+			var subject = NewTsvIndexer(scanner(data), HeaderLine(3), Separator(","), Fields("c1"), SkipFooter(1), FooterPattern(regexp.MustCompile(`^(Total|$)`)))
+			var output = stringio.New()
+
+			err := subject.Analyze()
+			check(err)
+			subject.Sort()
+			err = subject.Transfer(output)
+			check(err)
+
+			It("indexes the rows between the header line and the footer", func() {
+				Expect(subject.Header).To(BeTrue())
+				Expect(subject.Lines).To(TlConsistOf(tl{"", 16, 6}, tl{cs("a"), 26, 4}, tl{cs("b"), 22, 4}))
+			})
+
+			It("removes them from the output", func() {
+				Expect(output.GetValueString()).To(Equal("c1,c2\na,1\nb,2\n"))
+			})
+		})
+
+		Context("when they are kept", func() {
+			var subject = NewTsvIndexer(scanner(data), SkipLines(2), HasHeader(), Separator(","), Fields("c1"),
+				SkipFooter(3), KeepPreamble(), KeepFooter())
+			var output = stringio.New()
+
+			err := subject.Analyze()
+			check(err)
+			subject.Sort()
+			err = subject.Transfer(output)
+			check(err)
+
+			It("writes them around the sorted rows", func() {
+				Expect(output.GetValueString()).To(Equal(data[:16] + "c1,c2\na,1\nb,2\n" + data[30:]))
+			})
+		})
+
+		Context("when the lines are terminated by CR", func() {
+			var subject = NewTsvIndexer(scanner("c1,c2\rb,2\ra,1\rTotal,3\r"), HasHeader(), Separator(","), Fields("c1"), SkipFooter(1))
+
+			err := subject.Analyze()
+			check(err)
+
+			It("finds the footer on the lines of the scanner", func() {
+				Expect(subject.Lines).To(TlConsistOf(tl{"", 0, 6}, tl{cs("b"), 6, 4}, tl{cs("a"), 10, 4}))
+			})
+		})
+
+		Context("when the TSV is encoded", func() {
+			var data = "\xFF\xFE" + utf16le("c1,c2\nb,2\na,1\nTotal,3\n")
+			var subject = NewTsvIndexer(scanner(data), HasHeader(), Separator(","), Fields("c1"),
+				FooterPattern(regexp.MustCompile(`^Total`)))
+			var output = stringio.New()
+
+			err := subject.Analyze()
+			check(err)
+			subject.Sort()
+			err = subject.Transfer(output)
+			check(err)
+
+			It("matches the pattern on the decoded lines", func() {
+				Expect(output.GetValueString()).To(Equal("\xFF\xFE" + utf16le("c1,c2\na,1\nb,2\n")))
+			})
+		})
+
+		Context("when the footer starts inside a quoted record", func() {
+			var subject = NewTsvIndexer(scanner("c1,c2\nb,2\n\"a\nTotal\",1\n"), HasHeader(), Separator(","), Fields("c1"),
+				FooterPattern(regexp.MustCompile(`^Total`)))
+
+			It("returns an error", func() {
+				Expect(subject.Analyze()).To(Equal(ErrFooterInRecord))
+			})
+		})
+
+		Context("when the indexer is created", func() {
+			var file = &readCountingFile{StringIO: stringio.NewFromString("Sales report\nc1,c2\nb,2\nTotal,2\n")}
+			var subject = NewTsvIndexer(func() *Scanner { return NewScanner(file) }, SkipLines(1), HasHeader(), Fields("c1"),
+				FooterPattern(regexp.MustCompile(`^Total`)), SniffDialect(10))
+			var reads = file.reads
+
+			err := subject.Analyze()
+			check(err)
+
+			It("reads the preamble, the footer and the dialect in Analyze", func() {
+				Expect(reads).To(BeZero())
+				Expect(subject.Dialect).ToNot(BeNil())
+				Expect(subject.Lines).To(TlConsistOf(tl{"", 13, 6}, tl{cs("b"), 19, 4}))
+			})
+		})
+
+		Context("when the footer pattern matches all the rows", func() {
+			var subject = NewTsvIndexer(scanner("a,1\nb,2\n"), Separator(","), Fields("var1"), FooterPattern(regexp.MustCompile(`,`)))
+
+			err := subject.Analyze()
+			check(err)
+
+			It("keeps the first row", func() {
+				Expect(subject.Lines).To(TlConsistOf(tl{cs("a"), 0, 4}))
+			})
+		})
+	})
+
+	Describe("with a byte order mark", func() {
+		var sc = scanner("\xEF\xBB\xBFc1,c2\nb,2\na,1\n")
+		var subject = NewTsvIndexer(sc, HasHeader(), Separator(","), Fields("c1"))
+		var output = stringio.New()
+
+		err := subject.Analyze()
+		check(err)
+		subject.Sort()
+		err = subject.Transfer(output)
+		check(err)
+
+		It("finds the header fields", func() {
+			Expect(subject.Lines).To(TlConsistOf(tl{"", 3, 6}, tl{cs("a"), 13, 4}, tl{cs("b"), 9, 4}))
+		})
+
+		It("keeps the byte order mark in the output", func() {
+			Expect(output.GetValueString()).To(Equal("\xEF\xBB\xBFc1,c2\na,1\nb,2\n"))
+		})
+	})
+
 	Describe("Integration tests", func() {
 		var limit uint64 = 4200 << 20
 		var sc = scanner("c1,c2,c3\n1,0,42\n10,0,42\n,,42\na,b,c\ng,h,i\nd,e,f\n")
@@ -188,5 +584,40 @@ var _ = Describe("TsvIndexer", func() {
 		It("succeeds", func() {
 			Expect(output.GetValueString()).To(Equal("c1,c2,c3\n,,42\n1,0,42\n10,0,42\na,b,c\nd,e,f\ng,h,i\n"))
 		})
+
+		Context("with the Stable option", func() {
+			var input, expected = stableTsv(100)
+			var subject = NewTsvIndexer(scanner(input), HasHeader(), Separator(","), Fields("k"), Stable(),
+				SwapperOpts(limit, tempDir("", "tsv_swap_stable")))
+			var output = stringio.New()
+
+			backupGetMemoryUsage := GetMemoryUsage
+			GetMemoryUsage = func() *HeapMemStat {
+				return &HeapMemStat{0, limit + 42, 0, 0, 0, 0}
+			}
+			defer func() { GetMemoryUsage = backupGetMemoryUsage }()
+
+			subject.Lines = make(TsvLines, 0, 16) // 16 lines per dump
+
+			check(subject.Analyze())
+			subject.Sort()
+			check(subject.Transfer(output))
+
+			It("keeps the order of the TSV for the equal keys across the dumps", func() {
+				Expect(output.GetValueString()).To(Equal(expected))
+			})
+		})
 	})
 })
+
+// stableTsv returns a TSV of n rows with 3 distinct keys and the same TSV stably sorted on its keys.
+func stableTsv(n int) (input, expected string) {
+	keys := []string{"y", "x", "z"}
+	rows := make(map[string][]string)
+	for i := 0; i < n; i++ {
+		row := fmt.Sprintf("%s,%d\n", keys[i%len(keys)], i)
+		input += row
+		rows[keys[i%len(keys)]] = append(rows[keys[i%len(keys)]], row)
+	}
+	return "k,i\n" + input, "k,i\n" + strings.Join(rows["x"], "") + strings.Join(rows["y"], "") + strings.Join(rows["z"], "")
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"strconv"
+	"unicode"
 )
 
 // A ParseError is returned for parsing errors.
@@ -23,14 +25,42 @@ var (
 	ErrBareQuote = errors.New("bare \" in non-quoted-field")
 	// ErrQuote -> extraneous \" in field
 	ErrQuote = errors.New("extraneous \" in field")
+	// ErrEmptyColumnName -> empty column name in header
+	ErrEmptyColumnName = errors.New("empty column name")
+	// ErrDuplicateColumnName -> column name defined several times in header
+	ErrDuplicateColumnName = errors.New("duplicate column name")
 )
 
-// UnescapeSeparator cleans composed separator like `\t'.
+// A HeaderError is returned when the header row contains an invalid column name.
+// The first column is 0.
+type HeaderError struct {
+	Column int    // Column (field index) of the invalid name
+	Name   string // The invalid name
+	Err    error  // ErrEmptyColumnName or ErrDuplicateColumnName
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("header column %d %q: %s", e.Column, e.Name, e.Err)
+}
+
+// Is makes errors.Is(err, ErrEmptyColumnName) and errors.Is(err, ErrDuplicateColumnName) work.
+func (e *HeaderError) Is(target error) bool {
+	return target == e.Err
+}
+
+// UnescapeSeparator cleans composed separator like `\t' and returns its first byte.
+// Use UnescapeSeparatorBytes for separators longer than one byte.
 func UnescapeSeparator(separator string) byte {
-	if separator == "\\t" {
-		separator = "\t"
+	return UnescapeSeparatorBytes(separator)[0]
+}
+
+// UnescapeSeparatorBytes cleans composed separator like `\t' or `\u0001\u0002' and returns all its bytes
+// (e.g. `||' or a multi-byte UTF-8 character like `¦').
+func UnescapeSeparatorBytes(separator string) []byte {
+	if v, err := strconv.Unquote(`"` + separator + `"`); err == nil {
+		separator = v
 	}
-	return []byte(separator)[0]
+	return []byte(separator)
 }
 
 // TrimNewline removes newline characters at the end of line.
@@ -46,20 +76,69 @@ func TrimNewline(line []byte) []byte {
 // If LazyQuotes is true, a quote may appear in an unquoted field and a
 // non-doubled quote may appear in a quoted field.
 //
-// /!\ Warning:
+// A quoted field may contain newlines, the record is then read across several physical lines.
+// Offset and Limit cover the whole record. When the newline sequence is not kept by the underlying
+// Scanner, the embedded newlines are returned as `\n' (or as the delimiter defined by Scanner.SetDelimiter).
+//
+// If Comment is not 0, it is the comment character. Lines beginning with the
+// Comment character without preceding whitespace are ignored.
+// The Comment character is not interpreted inside a quoted field.
+//
+// The separator may be a sequence of bytes (e.g. `||' or `¦'), it is defined with SetSeparator.
 //
-// - It does not support `\r\n' in quoted field.
+// If Escape is not 0, the TSV uses the escape character dialect (e.g. PostgreSQL or MySQL text dumps) instead of RFC 4180.
+// The fields are not quoted, the escaped characters (e.g. `\t', `\n', `\\' or an escaped separator) are unescaped
+// in Row and a `\N' field is a NULL field returned as a nil slice. A line ending with an escaped newline is
+// continued on the next line.
 //
-// - It does not support comment.
+// If TrimLeadingSpace is true, leading white space in a field is ignored (even if the separator is a white space).
+//
+// If ReuseRow is true, the slice returned by Row and the unquoted fields are reused by the next call to ScanRow,
+// the rows are then parsed without allocation. Otherwise each row is copied from the buffers of the Scanner
+// (even with Scanner.NoCopy), so it can be kept.
+//
+// If HasHeader is true, the first row is the header. It is consumed by the first call to ScanRow
+// and the fields of the current row can be accessed by their column name:
+//
+// parser.HasHeader = true
+// parser.ScanRow()
+// name := parser.Field("name")
 type TsvParser struct {
 	*Scanner
-	err        error // Sticky error.
-	Separator  byte
-	QuoteChar  byte
-	LazyQuotes bool // allow lazy quotes
-	row        [][]byte
-	separator  []byte // for internal purpose (see parseFields function)
-	quoteChar  []byte // for internal purpose (see parseFields function)
+	err              error // Sticky error.
+	Separator        byte
+	QuoteChar        byte
+	Comment          byte // comment character (0 means no comment)
+	Escape           byte // escape character (0 means RFC 4180 quoting)
+	LazyQuotes       bool // allow lazy quotes
+	TrimLeadingSpace bool // leading white space in a field is ignored
+	HasHeader        bool // the first row is the header
+	ReuseRow         bool // the row is only valid until the next call to ScanRow
+	row              [][]byte
+	header           []string       // Column names of the header row.
+	columns          map[string]int // Index of the header columns.
+	record           []byte         // Buffer of a record read across several lines.
+	line             int            // Index of the first line of the current record.
+	offset           uint64         // Offset of the start of the current record.
+	limit            uint32         // Length of the current record including newline sequences.
+	unterminated     bool           // The current record is still open at the end of the input.
+	buffered         bool           // The current record is copied line by line in the record buffer.
+	separator        []byte         // for internal purpose (see parseFields function)
+	quoteChar        []byte         // for internal purpose (see parseFields function)
+	// onComment is called for each skipped comment line.
+	onComment func(offset uint64, limit uint32)
+
+	// Parsing stuff
+	positions []FieldPosition // Positions of the fields of the current row.
+	arena     []byte          // Buffer of the unquoted or unescaped fields of the current row.
+	trimCR    bool            // The CR that precedes the LF delimiter is dropped (see CsvReader).
+	comment   []byte          // Multi-byte comment character, it takes precedence over Comment (see CsvReader).
+}
+
+// A FieldPosition locates a field in the raw record returned by Bytes.
+type FieldPosition struct {
+	Start int // Index of the first byte of the field (the opening quote of a quoted field).
+	End   int // Index following the last byte of the field (the closing quote of a quoted field).
 }
 
 // NewTsvParser inatanciates a new TsvParser.
@@ -74,9 +153,22 @@ func NewTsvParser(sc *Scanner, separator byte) *TsvParser {
 	}
 }
 
+// SetSeparator defines a separator made of one or several bytes.
+// The Separator attribute is then the first byte of the separator. An empty separator is ignored.
+func (tp *TsvParser) SetSeparator(separator []byte) {
+	if len(separator) == 0 {
+		return
+	}
+	tp.Separator = separator[0]
+	tp.separator = separator
+}
+
 // SyncConfig synchronizes the internal configuration to the Separator and QuoteChar attributes.
+// A separator defined by SetSeparator is kept unless the Separator attribute has been modified.
 func (tp *TsvParser) SyncConfig() {
-	tp.separator = []byte{tp.Separator}
+	if len(tp.separator) == 0 || tp.separator[0] != tp.Separator {
+		tp.separator = []byte{tp.Separator}
+	}
 	tp.quoteChar = []byte{tp.QuoteChar}
 }
 
@@ -95,19 +187,58 @@ func (tp *TsvParser) Err() error {
 }
 
 // Row returns a slice of fields for the current row.
+// It is only valid until the next call to ScanRow when ReuseRow is true.
+// With the escape character dialect, a NULL field is a nil slice.
 func (tp *TsvParser) Row() [][]byte {
 	return tp.row
 }
 
+// FieldPositions returns the positions of the fields of the current row in the raw record (see Bytes).
+// e.g. tp.Bytes()[p.Start:p.End] is the field as written in the TSV (quoted or escaped).
+// The returned slice is only valid until the next call to ScanRow.
+func (tp *TsvParser) FieldPositions() []FieldPosition {
+	return tp.positions
+}
+
+// Line returns the index of the first line of the current row.
+func (tp *TsvParser) Line() int {
+	return tp.line
+}
+
+// Offset returns the byte offset of the current row.
+func (tp *TsvParser) Offset() uint64 {
+	return tp.offset
+}
+
+// Limit returns the byte length of the current row including all its newline sequences.
+func (tp *TsvParser) Limit() uint32 {
+	return tp.limit
+}
+
 // Reset resets parser and its underliying scanner. It freeing the memory.
 func (tp *TsvParser) Reset() {
 	tp.Scanner.Reset()
 	tp.row = make([][]byte, 0)
+	tp.record = nil
+	tp.header = nil
+	tp.columns = nil
+	tp.line = 0
+	tp.offset = 0
+	tp.limit = 0
 }
 
 // ScanRow advances the TSV parser to the next row.
+// With HasHeader, the header row is read by the first call.
 func (tp *TsvParser) ScanRow() bool {
-	b := tp.ScanLine()
+	if tp.HasHeader && !tp.scanHeader() {
+		return false
+	}
+	return tp.scanRow()
+}
+
+// scanRow advances the TSV parser to the next row whatever it is.
+func (tp *TsvParser) scanRow() bool {
+	b := tp.scanRecord()
 	if tp.Scanner.Err() != nil {
 		tp.err = tp.Scanner.Err()
 		b = !tp.IsLineEmpty()
@@ -120,63 +251,347 @@ func (tp *TsvParser) ScanRow() bool {
 	return b
 }
 
+// scanHeader reads the header row unless it is already read.
+func (tp *TsvParser) scanHeader() bool {
+	if tp.header != nil {
+		return true
+	}
+	if !tp.scanRow() || tp.err != nil {
+		return false
+	}
+
+	header := make([]string, len(tp.row))
+	columns := make(map[string]int, len(tp.row))
+	for i, field := range tp.row {
+		name := string(field)
+		if name == "" {
+			tp.err = &HeaderError{Column: i, Name: name, Err: ErrEmptyColumnName}
+			return false
+		}
+		if _, ok := columns[name]; ok {
+			tp.err = &HeaderError{Column: i, Name: name, Err: ErrDuplicateColumnName}
+			return false
+		}
+		header[i] = name
+		columns[name] = i
+	}
+	tp.header = header
+	tp.columns = columns
+	return true
+}
+
+// Header returns the column names of the header row (nil until the header row is read).
+func (tp *TsvParser) Header() []string {
+	return tp.header
+}
+
+// FieldIndex returns the index of the given column name in the rows, or -1 if the column does not exist.
+func (tp *TsvParser) FieldIndex(name string) int {
+	if i, ok := tp.columns[name]; ok {
+		return i
+	}
+	return -1
+}
+
+// Field returns the field of the current row for the given column name.
+// It returns nil if the column does not exist or if the row is too short.
+func (tp *TsvParser) Field(name string) []byte {
+	i := tp.FieldIndex(name)
+	if i < 0 || i >= len(tp.row) {
+		return nil
+	}
+	return tp.row[i]
+}
+
+// RowMap returns the fields of the current row indexed by their column name.
+// The fields are only valid until the next call to ScanRow.
+func (tp *TsvParser) RowMap() map[string][]byte {
+	m := make(map[string][]byte, len(tp.header))
+	for i, name := range tp.header {
+		if i < len(tp.row) {
+			m[name] = tp.row[i]
+		}
+	}
+	return m
+}
+
+// scanRecord reads the next record. It keeps reading lines while a quoted field is open.
+func (tp *TsvParser) scanRecord() bool {
+	b := tp.ScanLine()
+	for b && tp.isComment(tp.Bytes()) {
+		if tp.onComment != nil {
+			tp.onComment(tp.Scanner.Offset(), tp.Scanner.Limit())
+		}
+		b = tp.ScanLine()
+	}
+	tp.line = tp.Scanner.Line()
+	tp.offset = tp.Scanner.Offset()
+	tp.limit = tp.Scanner.Limit()
+	tp.unterminated = false
+	tp.buffered = false
+	if !b || !tp.isRecordOpen(tp.lineBytes(), false) {
+		return b
+	}
+
+	length := uint64(tp.limit)
+	tp.record = append(tp.record[:0], tp.lineBytes()...)
+	open := true
+	for open {
+		if !tp.ScanLine() {
+			break
+		}
+		open = tp.isRecordOpen(tp.lineBytes(), true)
+
+		length += tp.Scanner.limit
+		if length > tp.maxlen {
+			return tp.recordTooLong(open)
+		}
+		if !tp.keepnls {
+			tp.record = append(tp.record, tp.droppedDelimiter()...) // Restore the newline dropped by the scanner
+		}
+		tp.record = append(tp.record, tp.lineBytes()...)
+	}
+	tp.unterminated = open
+	tp.buffered = true
+	tp.limit = uint32(length)
+	tp.token = tp.record
+
+	return true
+}
+
+// lineBytes returns the current line of the Scanner (without the CR that precedes the LF delimiter in trimCR mode).
+// The CRs of the lines of a buffered record are already dropped.
+func (tp *TsvParser) lineBytes() []byte {
+	if tp.trimCR && !tp.buffered {
+		return bytes.TrimSuffix(tp.Bytes(), []byte{CR})
+	}
+	return tp.Bytes()
+}
+
+// recordTooLong handles a record longer than the maximum line length of the Scanner.
+// open is the state of the quoted field at the end of the last read line.
+func (tp *TsvParser) recordTooLong(open bool) bool {
+	if !tp.skiplong {
+		tp.err = &LineTooLongError{Line: tp.line, Offset: tp.offset}
+		return false
+	}
+
+	// Skip the rest of the record
+	for open && tp.ScanLine() {
+		open = tp.isRecordOpen(tp.Bytes(), true)
+	}
+	return tp.scanRecord()
+}
+
+// droppedDelimiter returns the delimiter restored in a record read across several lines.
+func (tp *TsvParser) droppedDelimiter() []byte {
+	if tp.delim != nil {
+		return tp.delim
+	}
+	return LFDelimiter
+}
+
+// isComment says if the given line is a comment line.
+func (tp *TsvParser) isComment(line []byte) bool {
+	if tp.comment != nil {
+		return bytes.HasPrefix(line, tp.comment)
+	}
+	return tp.Comment != 0 && len(line) > 0 && line[0] == tp.Comment
+}
+
+// isRecordOpen says if the record continues on the next line.
+// open is the state of the record at the beginning of the line.
+func (tp *TsvParser) isRecordOpen(line []byte, open bool) bool {
+	if tp.Escape != 0 {
+		return tp.isNewlineEscaped(line)
+	}
+	return tp.isQuoteOpen(line, open)
+}
+
+// isNewlineEscaped says if the newline at the end of the given line is escaped.
+func (tp *TsvParser) isNewlineEscaped(line []byte) bool {
+	line = tp.trimDelimiter(line)
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == tp.Escape; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// isQuoteOpen says if a quoted field is still open at the end of the given line.
+// open is the state of the quoted field at the beginning of the line.
+func (tp *TsvParser) isQuoteOpen(line []byte, open bool) bool {
+	line = tp.trimDelimiter(line)
+	if bytes.IndexByte(line, tp.QuoteChar) < 0 {
+		return open
+	}
+
+	start := !open // at the beginning of a field
+	for i := 0; i < len(line); i++ {
+		b := line[i]
+		switch {
+		case start && tp.TrimLeadingSpace && tp.leadingSpaces(line, i) > i:
+			i = tp.leadingSpaces(line, i) - 1 // The field starts after its leading white spaces
+		case open:
+			if b != tp.QuoteChar {
+				continue
+			}
+			if i+1 < len(line) && line[i+1] == tp.QuoteChar {
+				i++ // escaped quote
+				continue
+			}
+			// A bare quote in a quoted field is kept in lazy mode, otherwise parseFields raises an error
+			if i+1 == len(line) || bytes.HasPrefix(line[i+1:], tp.separator) || !tp.LazyQuotes {
+				open = false
+			}
+		case start && b == tp.QuoteChar:
+			open = true
+			start = false
+		case b == tp.Separator && bytes.HasPrefix(line[i:], tp.separator):
+			start = true
+			i += len(tp.separator) - 1
+		default:
+			start = false
+		}
+	}
+	return open
+}
+
 // Fields parser for the current read row
 func (tp *TsvParser) parseFields() [][]byte {
-	row := TrimNewline(tp.Bytes())
+	row := tp.Bytes()
+	if tp.keepnls {
+		row = tp.trimDelimiter(row)
+	}
+	if tp.trimCR && !tp.buffered {
+		row = bytes.TrimSuffix(row, []byte{CR})
+	}
+	fields := tp.row[:0]
+	if !tp.ReuseRow {
+		fields = nil
+		row = append(make([]byte, 0, len(row)), row...) // The kept fields must not reference the buffers of the scanner
+	}
+	tp.positions = tp.positions[:0]
+
+	if tp.Escape != 0 {
+		return tp.parseEscapedFields(fields, row)
+	}
 	if !bytes.Contains(row, tp.quoteChar) {
 		// unquoted line (fast mode)
-		return bytes.Split(row, tp.separator)
+		return tp.splitFields(fields, row)
 	}
 	// quoted line (normal mode)
-	fields := [][]byte{}
-	r := newReader(row)
-	field := tp.parseField(r)
+	tp.resetArena(len(row))
+	r := reader{row: row}
+	start := tp.leadingSpaces(row, r.index)
+	field := tp.parseField(&r)
 	for field != nil {
-		fields = append(fields, field)
-		field = tp.parseField(r)
+		end := r.index
+		if r.sep {
+			end -= len(tp.separator) // The field ends before its separator
+		}
+		fields = tp.appendField(fields, field, start, end)
+		start = tp.leadingSpaces(row, r.index)
+		field = tp.parseField(&r)
 	}
 	return fields
 }
 
-func (tp *TsvParser) parseField(r *reader) []byte {
-	var field bytes.Buffer
+// splitFields splits the unquoted row on the separators.
+func (tp *TsvParser) splitFields(fields [][]byte, row []byte) [][]byte {
+	if fields == nil {
+		fields = make([][]byte, 0, bytes.Count(row, tp.separator)+1)
+	}
+
+	start := tp.leadingSpaces(row, 0)
+	for {
+		i := bytes.Index(row[start:], tp.separator)
+		if i < 0 {
+			break
+		}
+		end := start + i
+		fields = tp.appendField(fields, row[start:end:end], start, end)
+		start = tp.leadingSpaces(row, end+len(tp.separator))
+	}
+	return tp.appendField(fields, row[start:], start, len(row))
+}
+
+// leadingSpaces returns the index following the white spaces that start at index i when TrimLeadingSpace is true.
+func (tp *TsvParser) leadingSpaces(row []byte, i int) int {
+	if !tp.TrimLeadingSpace {
+		return i
+	}
+	if j := bytes.IndexFunc(row[i:], func(r rune) bool { return !unicode.IsSpace(r) }); j >= 0 {
+		return i + j
+	}
+	return len(row)
+}
+
+// appendField appends the field and its position to the current row.
+func (tp *TsvParser) appendField(fields [][]byte, field []byte, start, end int) [][]byte {
+	tp.positions = append(tp.positions, FieldPosition{Start: start, End: end})
+	return append(fields, field)
+}
+
+// resetArena prepares the buffer of the unquoted fields of a row of n bytes.
+// The unquoted fields are never longer than the row, so the buffer is never reallocated during the parsing of the row.
+func (tp *TsvParser) resetArena(n int) {
+	if !tp.ReuseRow || cap(tp.arena) < n {
+		tp.arena = make([]byte, 0, n)
+	}
+	tp.arena = tp.arena[:0]
+}
 
-	b, ok := r.readByte()
-	if !ok {
-		if r.isLastByte(tp.Separator) && !r.eof {
-			r.eof = true
-			return []byte{} // Empty field
+// newField returns an empty field at the end of the arena.
+func (tp *TsvParser) newField() []byte {
+	return tp.arena[len(tp.arena):]
+}
+
+// commitField keeps the given field (returned by newField) in the arena.
+func (tp *TsvParser) commitField(field []byte) []byte {
+	tp.arena = tp.arena[:len(tp.arena)+len(field)]
+	return field[:len(field):len(field)]
+}
+
+func (tp *TsvParser) parseField(r *reader) []byte {
+	sep := r.sep // The previous field is followed by a separator
+	r.sep = false
+	r.index = tp.leadingSpaces(r.row, r.index)
+	if r.isEOF() {
+		if sep {
+			return []byte{} // Empty field (or made of white spaces) at the end of the row
 		}
 		return nil
 	}
+	if r.skip(tp.separator) {
+		return []byte{} // Empty field
+	}
 
+	b, _ := r.readByte()
 	switch b {
-	case tp.Separator:
-		return []byte{} // Empty field
 	case tp.QuoteChar:
 		// quoted field
-		return tp.quotedField(r, field)
+		return tp.quotedField(r, tp.newField())
 
 	default:
 		// unquoted field
-		return tp.unquotedField(r, field, b)
+		return tp.unquotedField(r, tp.newField(), b)
 	}
 }
 
-func (tp *TsvParser) quotedField(r *reader, field bytes.Buffer) []byte {
+func (tp *TsvParser) quotedField(r *reader, field []byte) []byte {
 	var b byte
 	var ok bool
 
 	// Fast mode
 	// Enabled when the field is basic (e.g `...,"col 1",...')
-	si := r.indexOf(tp.Separator)
-	qci := r.indexOf(tp.QuoteChar)
+	si := r.indexOf(tp.separator)
+	qci := r.indexByte(tp.QuoteChar)
 	if qci >= 0 && qci < si && qci+1 == si {
 		// qci < si -> there is no separator occurrence until the end of the field
 		// qci+1 == si -> end of quoted field detection
-		field.Write(r.readBytesTo(si))
-		v := field.Bytes()
-		return v[:len(v)-1] // Drop the ending quotechar
+		field = append(field, r.readBytesTo(si, len(tp.separator))...)
+		return tp.commitField(field[:len(field)-1]) // Drop the ending quotechar
 	}
 
 	// Normal mode
@@ -189,16 +604,19 @@ func (tp *TsvParser) quotedField(r *reader, field bytes.Buffer) []byte {
 				tp.err = tp.error(r.index, ErrQuote)
 				return nil
 			}
-			return field.Bytes()
+			return tp.commitField(field)
 		}
 
 		// CSV quote escaping case
 		if b == tp.QuoteChar {
-			b, ok = r.readByte() // read next byte after the double-quote
-			if b == tp.Separator || !ok {
+			if r.skip(tp.separator) || r.isEOF() {
 				// End of field or end of row reached
-				return field.Bytes()
+				if len(field) == 0 {
+					return []byte{} // Empty field (nil means no more field)
+				}
+				return tp.commitField(field)
 			}
+			b, _ = r.readByte() // read next byte after the double-quote
 			if b != tp.QuoteChar {
 				if !tp.LazyQuotes {
 					r.index--
@@ -206,27 +624,25 @@ func (tp *TsvParser) quotedField(r *reader, field bytes.Buffer) []byte {
 					return nil
 				}
 				// accept the bare quote
-				field.WriteRune('"')
+				field = append(field, tp.QuoteChar)
 			}
 		}
 
-		field.WriteByte(b)
+		field = append(field, b)
 	}
 }
 
-func (tp *TsvParser) unquotedField(r *reader, field bytes.Buffer, b byte) []byte {
-	var ok bool
-
+func (tp *TsvParser) unquotedField(r *reader, field []byte, b byte) []byte {
 	// Fast mode
 	// Enabled when the field does not contain a double-quote (e.g `..,col1,..')
-	si := r.indexOf(tp.Separator)
-	qci := r.indexOf(tp.QuoteChar)
-	if qci >= 0 && qci == -1 || si < qci {
+	si := r.indexOf(tp.separator)
+	qci := r.indexByte(tp.QuoteChar)
+	if qci == -1 || (si >= 0 && si < qci) {
 		// qci == -1 -> no longer quote char in last part of the row
 		// si < qsi -> there is no quote char until the next separator
-		field.WriteByte(b)
-		field.Write(r.readBytesTo(si))
-		return field.Bytes()
+		field = append(field, b)
+		field = append(field, r.readBytesTo(si, len(tp.separator))...)
+		return tp.commitField(field)
 	}
 
 	// At this point, a quote char is present in the current unquoted field (e.g `col"5')
@@ -235,12 +651,12 @@ func (tp *TsvParser) unquotedField(r *reader, field bytes.Buffer, b byte) []byte
 	// Normal mode
 	// Enabled when the field contains a double-quote or not
 	for {
-		field.WriteByte(b)
-		b, ok = r.readByte()
-		if b == tp.Separator || !ok {
+		field = append(field, b)
+		if r.skip(tp.separator) || r.isEOF() {
 			// End of field or end of row reached
-			return field.Bytes()
+			return tp.commitField(field)
 		}
+		b, _ = r.readByte()
 
 		if !tp.LazyQuotes && b == tp.QuoteChar {
 			tp.err = tp.error(r.index, ErrBareQuote)
@@ -249,6 +665,80 @@ func (tp *TsvParser) unquotedField(r *reader, field bytes.Buffer, b byte) []byte
 	}
 }
 
+// parseEscapedFields splits the row on the separators that are not escaped and unescapes the fields.
+func (tp *TsvParser) parseEscapedFields(fields [][]byte, row []byte) [][]byte {
+	if row == nil {
+		row = []byte{} // The field of an empty line is empty, not NULL
+	}
+	if bytes.IndexByte(row, tp.Escape) < 0 {
+		// unescaped line (fast mode)
+		return tp.splitFields(fields, row)
+	}
+
+	tp.resetArena(len(row))
+	start := 0
+	escaped := false
+	for i := 0; i < len(row); {
+		switch {
+		case row[i] == tp.Escape:
+			escaped = true
+			i += 2 // The escaped byte can not be a separator
+		case bytes.HasPrefix(row[i:], tp.separator):
+			fields = tp.appendField(fields, tp.unescapeField(row[start:i:i], escaped), start, i)
+			i += len(tp.separator)
+			start = i
+			escaped = false
+		default:
+			i++
+		}
+	}
+	return tp.appendField(fields, tp.unescapeField(row[start:], escaped), start, len(row))
+}
+
+// unescapeField returns the unescaped field or nil for a NULL field.
+func (tp *TsvParser) unescapeField(field []byte, escaped bool) []byte {
+	if !escaped {
+		return field
+	}
+	if len(field) == 2 && field[0] == tp.Escape && field[1] == 'N' {
+		return nil // NULL
+	}
+
+	v := tp.newField()
+	for i := 0; i < len(field); i++ {
+		b := field[i]
+		if b == tp.Escape && i+1 < len(field) {
+			i++
+			b = unescapeByte(field[i])
+		}
+		v = append(v, b)
+	}
+	return tp.commitField(v)
+}
+
+// unescapeByte returns the character represented by the given byte following an escape character.
+func unescapeByte(b byte) byte {
+	switch b {
+	case '0':
+		return 0
+	case 'b':
+		return '\b'
+	case 'f':
+		return '\f'
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'v':
+		return '\v'
+	case 'Z':
+		return 0x1A // Ctrl-Z (MySQL)
+	}
+	return b // e.g. the escape character itself, a separator or a newline
+}
+
 // ------------------ //
 // Parsing stuff      //
 // ------------------ //
@@ -256,14 +746,7 @@ func (tp *TsvParser) unquotedField(r *reader, field bytes.Buffer, b byte) []byte
 type reader struct {
 	index int
 	row   []byte
-	eof   bool
-}
-
-func newReader(row []byte) *reader {
-	return &reader{
-		index: 0,
-		row:   row,
-	}
+	sep   bool // The last read field is followed by a separator.
 }
 
 func (r *reader) readByte() (byte, bool) {
@@ -274,24 +757,43 @@ func (r *reader) readByte() (byte, bool) {
 	return r.row[r.index], true
 }
 
-func (r *reader) readBytesTo(i int) []byte {
+// readBytesTo reads the bytes until the index i and skips the n bytes of the separator.
+func (r *reader) readBytesTo(i, n int) []byte {
 	if i == -1 {
 		defer func() { r.index = len(r.row) }()
 		return r.row[r.index:]
 	}
 	defer func() {
 		// i is relative to r.index
-		// 1 is the separator character (byte)
-		r.index = r.index + i + 1
+		r.index = r.index + i + n
+		r.sep = true
 	}()
 	return r.row[r.index:(r.index + i)]
 }
 
+// returns the index of the next occurrence of sep or -1 if sep is not present
+func (r *reader) indexOf(sep []byte) int {
+	if len(sep) == 1 {
+		return bytes.IndexByte(r.row[r.index:], sep[0])
+	}
+	return bytes.Index(r.row[r.index:], sep)
+}
+
 // returns the index of the next occurrence of b or -1 if b is not present
-func (r *reader) indexOf(b byte) int {
+func (r *reader) indexByte(b byte) int {
 	return bytes.IndexByte(r.row[r.index:], b)
 }
 
-func (r *reader) isLastByte(b byte) bool {
-	return r.row[len(r.row)-1] == b
+// skip moves after sep if the row continues with sep.
+func (r *reader) skip(sep []byte) bool {
+	if r.isEOF() || r.row[r.index] != sep[0] || !bytes.HasPrefix(r.row[r.index:], sep) {
+		return false
+	}
+	r.index += len(sep)
+	r.sep = true
+	return true
+}
+
+func (r *reader) isEOF() bool {
+	return r.index >= len(r.row)
 }
@@ -1,6 +1,7 @@
 package iosupport_test
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -57,6 +58,205 @@ var _ = Describe("TsvParser", func() {
 			})
 		})
 
+		Context("when quoted fields contain newlines", func() {
+			var file = stringio.NewFromString("c1,c2,c3\nval1,\"multi\nline\",val3\n\"a\r\nb\",\"c \"\"d\"\"\ne\",f\nv1,v2,v3\n")
+
+			var sc = NewScanner(file)
+			var subject = NewTsvParser(sc, ',')
+
+			var actual = [][]string{}
+			var lines = []int{}
+			var offsets = []uint64{}
+			var limits = []uint32{}
+			var expected = [][]string{
+				{"c1", "c2", "c3"},
+				{"val1", "multi\nline", "val3"},
+				{"a\nb", "c \"d\"\ne", "f"},
+				{"v1", "v2", "v3"},
+			}
+
+			for subject.ScanRow() {
+				check(subject.Err())
+				actual = append(actual, toStringSlice(subject.Row()))
+				lines = append(lines, subject.Line())
+				offsets = append(offsets, subject.Offset())
+				limits = append(limits, subject.Limit())
+			}
+
+			It("parses the TSV", func() {
+				Expect(actual).To(Equal(expected))
+			})
+
+			It("returns the first line's index of each record", func() {
+				Expect(lines).To(Equal([]int{1, 2, 4, 7}))
+			})
+
+			It("returns the offset of each record", func() {
+				Expect(offsets).To(Uint64ConsistOf(0, 9, 32, 53))
+			})
+
+			It("returns the limit of each record", func() {
+				Expect(limits).To(Uint32ConsistOf(9, 23, 21, 9))
+			})
+		})
+
+		Context("when the newline sequence is kept and quoted fields contain newlines", func() {
+			var file = stringio.NewFromString("\"a\r\nb\",c\r\nd,e\r\n")
+
+			var sc = NewScanner(file)
+			sc.KeepNewlineSequence(true)
+			var subject = NewTsvParser(sc, ',')
+
+			var actual = [][]string{}
+
+			for subject.ScanRow() {
+				check(subject.Err())
+				actual = append(actual, toStringSlice(subject.Row()))
+			}
+
+			It("keeps the original newline sequence in the field", func() {
+				Expect(actual).To(Equal([][]string{{"a\r\nb", "c"}, {"d", "e"}}))
+			})
+		})
+
+		Context("when a quoted field is not closed", func() {
+			var file = stringio.NewFromString("c1,\"c2\nc3\n")
+
+			var subject = NewTsvParser(NewScanner(file), ',')
+
+			It("detects the error", func() {
+				subject.ScanRow()
+				Expect(subject.Err()).To(HaveOccurred())
+			})
+		})
+
+		Context("with comment lines", func() {
+			var file = stringio.NewFromString("# header comment\nc1,c2\nv1,\"multi\n# not a comment\"\n#v2,v3\n #v4,v5\n")
+
+			var subject = NewTsvParser(NewScanner(file), ',')
+			subject.Comment = '#'
+
+			var actual = [][]string{}
+			var lines = []int{}
+			var expected = [][]string{
+				{"c1", "c2"},
+				{"v1", "multi\n# not a comment"},
+				{" #v4", "v5"},
+			}
+
+			for subject.ScanRow() {
+				check(subject.Err())
+				actual = append(actual, toStringSlice(subject.Row()))
+				lines = append(lines, subject.Line())
+			}
+
+			It("skips the comment lines", func() {
+				Expect(actual).To(Equal(expected))
+			})
+
+			It("counts the comment lines", func() {
+				Expect(lines).To(Equal([]int{2, 3, 6}))
+			})
+		})
+
+		Context("with a separator made of several bytes", func() {
+			var tsvParserSeparators = []struct {
+				separator string
+				row       string
+				expected  []string
+			}{
+				{"||", `c1||"c||2"||"c""3"||||c|5||`, []string{"c1", "c||2", `c"3`, "", "c|5", ""}},
+				{"¦", `c1¦"c¦2"¦"c""3"¦¦c|5¦`, []string{"c1", "c¦2", `c"3`, "", "c|5", ""}},
+				{`\u0001\u0002`, "c1\x01\x02\"c\x01\x022\"\x01\x02c\x013", []string{"c1", "c\x01\x022", "c\x013"}},
+			}
+
+			It("parses the TSV", func() {
+				for _, input := range tsvParserSeparators {
+					var subject = NewTsvParser(NewScanner(stringio.NewFromString(input.row)), ',')
+					subject.SetSeparator(UnescapeSeparatorBytes(input.separator))
+					subject.ScanRow()
+					check(subject.Err())
+
+					Expect(toStringSlice(subject.Row())).To(Equal(input.expected))
+				}
+			})
+
+			It("detects the multi-line records", func() {
+				var subject = NewTsvParser(NewScanner(stringio.NewFromString("c1||\"c\n||2\"\nc3||c4\n")), ',')
+				subject.SetSeparator([]byte("||"))
+				var actual = [][]string{}
+
+				for subject.ScanRow() {
+					check(subject.Err())
+					actual = append(actual, toStringSlice(subject.Row()))
+				}
+
+				Expect(actual).To(Equal([][]string{{"c1", "c\n||2"}, {"c3", "c4"}}))
+			})
+
+			It("keeps the current separator when the given one is empty", func() {
+				var subject = NewTsvParser(NewScanner(stringio.NewFromString("a;b\n")), ';')
+				subject.SetSeparator([]byte{})
+
+				Expect(subject.ScanRow()).To(BeTrue())
+				Expect(subject.Separator).To(Equal(byte(';')))
+				Expect(toStringSlice(subject.Row())).To(Equal([]string{"a", "b"}))
+			})
+		})
+
+		Context("with the escape character dialect", func() {
+			var file = stringio.NewFromString("c1\tc\\\\t2\t\"c3\"\na\\tb\\\\c\\nd\t\\N\t\\\\N\ne\\\nf\t\\\tg\t\n")
+
+			var subject = NewTsvParser(NewScanner(file), '\t')
+			subject.Escape = '\\'
+
+			var actual = [][]string{}
+			var nulls = []bool{}
+			var lines = []int{}
+			var expected = [][]string{
+				{"c1", `c\t2`, `"c3"`},
+				{"a\tb\\c\nd", "", `\N`},
+				{"e\nf", "\tg", ""},
+			}
+
+			for subject.ScanRow() {
+				check(subject.Err())
+				actual = append(actual, toStringSlice(subject.Row()))
+				nulls = append(nulls, subject.Row()[1] == nil)
+				lines = append(lines, subject.Line())
+			}
+
+			It("unescapes the fields", func() {
+				Expect(actual).To(Equal(expected))
+			})
+
+			It("returns the NULL fields as nil", func() {
+				Expect(nulls).To(Equal([]bool{false, true, false}))
+			})
+
+			It("continues the records on escaped newlines", func() {
+				Expect(lines).To(Equal([]int{1, 2, 3}))
+			})
+
+			for _, reuse := range []bool{true, false} {
+				reuse := reuse
+
+				It(fmt.Sprintf("returns an empty field for an empty line (ReuseRow: %t)", reuse), func() {
+					subject := NewTsvParser(NewScanner(stringio.NewFromString("\na\n\n\\N\n")), '\t')
+					subject.Escape = '\\'
+					subject.ReuseRow = reuse
+
+					nulls := []bool{}
+					for subject.ScanRow() {
+						check(subject.Err())
+						Expect(subject.Row()).To(HaveLen(1))
+						nulls = append(nulls, subject.Row()[0] == nil)
+					}
+					Expect(nulls).To(Equal([]bool{false, false, false, true}))
+				})
+			}
+		})
+
 		Context("when there is a quote error", func() {
 			var tsvParserErrQuote = []struct {
 				col int
@@ -87,6 +287,233 @@ var _ = Describe("TsvParser", func() {
 				subject.ScanRow()
 				Expect(subject.Err().Error()).To(Equal("line 1, character 6: " + ErrBareQuote.Error()))
 			})
+
+			It("detects the error in the last field", func() {
+				var subject = NewTsvParser(NewScanner(stringio.NewFromString(`c1,c2,c"3`)), ',')
+				subject.ScanRow()
+				Expect(subject.Err().Error()).To(Equal("line 1, character 8: " + ErrBareQuote.Error()))
+			})
+		})
+
+		Context("with TrimLeadingSpace", func() {
+			var file = stringio.NewFromString(" c1,\t c2 ,  \"c 3\",\n  c4,\"c\n5\"\n")
+
+			var subject = NewTsvParser(NewScanner(file), ',')
+			subject.TrimLeadingSpace = true
+
+			It("ignores the leading white spaces of the fields", func() {
+				Expect(subject.ScanRow()).To(BeTrue())
+				Expect(toStringSlice(subject.Row())).To(Equal([]string{"c1", "c2 ", "c 3", ""}))
+				Expect(subject.FieldPositions()[2]).To(Equal(FieldPosition{Start: 12, End: 17}))
+
+				Expect(subject.ScanRow()).To(BeTrue())
+				Expect(toStringSlice(subject.Row())).To(Equal([]string{"c4", "c\n5"}))
+				check(subject.Err())
+			})
+		})
+	})
+
+	Describe("#FieldPositions", func() {
+		var positions = func(data string, setup func(*TsvParser)) ([]string, []FieldPosition) {
+			var subject = NewTsvParser(NewScanner(stringio.NewFromString(data)), ',')
+			setup(subject)
+			Expect(subject.ScanRow()).To(BeTrue())
+
+			raw := []string{}
+			for _, p := range subject.FieldPositions() {
+				raw = append(raw, string(subject.Bytes()[p.Start:p.End]))
+			}
+			return raw, subject.FieldPositions()
+		}
+
+		It("locates the unquoted fields", func() {
+			raw, p := positions("c1,,c3,\n", func(*TsvParser) {})
+			Expect(raw).To(Equal([]string{"c1", "", "c3", ""}))
+			Expect(p).To(Equal([]FieldPosition{{0, 2}, {3, 3}, {4, 6}, {7, 7}}))
+		})
+
+		It("locates the quoted fields with their quotes", func() {
+			raw, _ := positions("c1,\"c,2\",\"\",\"c \"\"4\"\"\",c5\n", func(*TsvParser) {})
+			Expect(raw).To(Equal([]string{"c1", `"c,2"`, `""`, `"c ""4"""`, "c5"}))
+		})
+
+		It("locates the quoted fields after their leading white spaces", func() {
+			raw, p := positions("a,  \"b\",\t\"c,d\"\n", func(tp *TsvParser) { tp.TrimLeadingSpace = true })
+			Expect(raw).To(Equal([]string{"a", `"b"`, `"c,d"`}))
+			Expect(p).To(Equal([]FieldPosition{{0, 1}, {4, 7}, {9, 14}}))
+		})
+
+		It("locates the fields with a separator made of several bytes", func() {
+			raw, _ := positions("c1||\"c||2\"||c3", func(tp *TsvParser) { tp.SetSeparator([]byte("||")) })
+			Expect(raw).To(Equal([]string{"c1", `"c||2"`, "c3"}))
+		})
+
+		It("locates the escaped fields", func() {
+			raw, _ := positions("c\\\t1\t\\N\tc3\n", func(tp *TsvParser) {
+				tp.Separator = '\t'
+				tp.Escape = '\\'
+				tp.SyncConfig()
+			})
+			Expect(raw).To(Equal([]string{"c\\\t1", "\\N", "c3"}))
+		})
+
+		It("locates the fields of a multi-line record", func() {
+			raw, _ := positions("c1,\"c\n2\",c3\n", func(*TsvParser) {})
+			Expect(raw).To(Equal([]string{"c1", "\"c\n2\"", "c3"}))
+		})
+	})
+
+	Describe("#Row", func() {
+		Context("when the rows are kept", func() {
+			var cases = []struct {
+				name   string
+				input  string
+				escape byte
+				rows   [][]string
+			}{
+				{"unquoted", "aa,bb\ncc,dd\n", 0, [][]string{{"aa", "bb"}, {"cc", "dd"}}},
+				{"quoted", "\"a,a\",bb\n\"c,c\",dd\n", 0, [][]string{{"a,a", "bb"}, {"c,c", "dd"}}},
+				{"quoted across lines", "\"a\na\",bb\n\"c\nc\",dd\n", 0, [][]string{{"a\na", "bb"}, {"c\nc", "dd"}}},
+				{"escaped", "a\\,a,bb\nc\\,c,dd\n", '\\', [][]string{{"a,a", "bb"}, {"c,c", "dd"}}},
+			}
+
+			for _, c := range cases {
+				c := c
+
+				It(fmt.Sprintf("does not override the previous %s rows", c.name), func() {
+					subject := NewTsvParser(NewScanner(stringio.NewFromString(c.input)), ',')
+					subject.Escape = c.escape
+
+					var rows [][][]byte
+					for subject.ScanRow() {
+						check(subject.Err())
+						rows = append(rows, subject.Row())
+					}
+
+					actual := [][]string{}
+					for _, row := range rows {
+						actual = append(actual, toStringSlice(row))
+					}
+					Expect(actual).To(Equal(c.rows))
+				})
+			}
+		})
+	})
+
+	Describe("#ReuseRow", func() {
+		var file = stringio.NewFromString("a,\"b\"\"1\",c\nd,\"e\",f\n")
+		var subject = NewTsvParser(NewScanner(file), ',')
+		subject.ReuseRow = true
+
+		It("reuses the row", func() {
+			Expect(subject.ScanRow()).To(BeTrue())
+			first := subject.Row()
+			Expect(toStringSlice(first)).To(Equal([]string{"a", "b\"1", "c"}))
+
+			Expect(subject.ScanRow()).To(BeTrue())
+			Expect(toStringSlice(subject.Row())).To(Equal([]string{"d", "e", "f"}))
+			Expect(&first[0]).To(Equal(&subject.Row()[0]))
+		})
+
+		Context("when it is off", func() {
+			var sc = NewScanner(stringio.NewFromString("a,\"b\nb\",c\nd,\"e\"\"\",f\ng,h,i\n"))
+			sc.NoCopy(true)
+			var subject = NewTsvParser(sc, ',')
+
+			var rows = [][][]byte{}
+			for subject.ScanRow() {
+				check(subject.Err())
+				rows = append(rows, subject.Row())
+			}
+
+			It("keeps the previous rows", func() {
+				actual := [][]string{}
+				for _, row := range rows {
+					actual = append(actual, toStringSlice(row))
+				}
+				Expect(actual).To(Equal([][]string{{"a", "b\nb", "c"}, {"d", "e\"", "f"}, {"g", "h", "i"}}))
+			})
+		})
+	})
+
+	Describe("#HasHeader", func() {
+		Context("with a well formatted header", func() {
+			var file = stringio.NewFromString(heredoc.Doc(`name,age,city
+				Alice,42,Paris
+				Bob,7`))
+
+			var subject = NewTsvParser(NewScanner(file), ',')
+			subject.HasHeader = true
+
+			var names = []string{}
+			var maps = []map[string]string{}
+			var cities = []string{}
+			var lines = []int{}
+			for subject.ScanRow() {
+				check(subject.Err())
+				names = append(names, string(subject.Field("name")))
+				cities = append(cities, string(subject.Field("city")))
+				m := map[string]string{}
+				for k, v := range subject.RowMap() {
+					m[k] = string(v)
+				}
+				maps = append(maps, m)
+				lines = append(lines, subject.Line())
+			}
+			check(subject.Err())
+
+			It("consumes the header row", func() {
+				Expect(subject.Header()).To(Equal([]string{"name", "age", "city"}))
+				Expect(lines).To(Equal([]int{2, 3}))
+			})
+
+			It("returns the index of the columns", func() {
+				Expect(subject.FieldIndex("age")).To(Equal(1))
+				Expect(subject.FieldIndex("unknown")).To(Equal(-1))
+			})
+
+			It("returns the fields by their column name", func() {
+				Expect(names).To(Equal([]string{"Alice", "Bob"}))
+				Expect(cities).To(Equal([]string{"Paris", ""}))
+				Expect(subject.Field("unknown")).To(BeNil())
+			})
+
+			It("returns a map view of the rows", func() {
+				Expect(maps).To(Equal([]map[string]string{
+					{"name": "Alice", "age": "42", "city": "Paris"},
+					{"name": "Bob", "age": "7"},
+				}))
+			})
+
+			It("reads the header again after a reset", func() {
+				subject.Reset()
+				Expect(subject.Header()).To(BeNil())
+				Expect(subject.ScanRow()).To(BeTrue())
+				Expect(subject.Header()).To(Equal([]string{"name", "age", "city"}))
+				Expect(string(subject.Field("name"))).To(Equal("Alice"))
+			})
+		})
+
+		Context("when a column name is empty", func() {
+			var subject = NewTsvParser(NewScanner(stringio.NewFromString("name,,city\nAlice,42,Paris")), ',')
+			subject.HasHeader = true
+
+			It("detects the error", func() {
+				Expect(subject.ScanRow()).To(BeFalse())
+				Expect(errors.Is(subject.Err(), ErrEmptyColumnName)).To(BeTrue())
+				Expect(subject.Err().Error()).To(Equal(`header column 1 "": empty column name`))
+			})
+		})
+
+		Context("when a column name is duplicated", func() {
+			var subject = NewTsvParser(NewScanner(stringio.NewFromString("name,age,name\nAlice,42,Paris")), ',')
+			subject.HasHeader = true
+
+			It("detects the error", func() {
+				Expect(subject.ScanRow()).To(BeFalse())
+				Expect(errors.Is(subject.Err(), ErrDuplicateColumnName)).To(BeTrue())
+				Expect(subject.Err().Error()).To(Equal(`header column 2 "name": duplicate column name`))
+			})
 		})
 	})
 })
@@ -135,6 +562,18 @@ func BenchmarkParseFieldsWithQuotesAndLongQuotedFields(b *testing.B) {
 	}
 }
 
+func BenchmarkParseFieldsWithQuotesAndReuseRow(b *testing.B) {
+	sc := NewScanner(stringio.NewFromString(""))
+
+	tp := NewTsvParser(sc, ',')
+	tp.ReuseRow = true
+	SetToken(tp, []byte(`c1,c2,c3,c4,c5,c6,"c,7",c8,c9,10`))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		SetRow(tp, ParseFields(tp))
+	}
+}
+
 func BenchmarkParseFieldsWithDoubleQuotes(b *testing.B) {
 	sc := NewScanner(stringio.NewFromString(""))
 
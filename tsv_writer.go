@@ -0,0 +1,203 @@
+package iosupport
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// A TsvWriter writes records to a TSV-encoded file.
+// As returned by NewTsvWriter, a TsvWriter writes records terminated by a `\n' and quotes
+// a field only when it is needed (e.g. the field contains a separator, a quote or a newline).
+// Its settings are the same as TsvParser ones, so the written TSV can be read back by a TsvParser
+// (the underlying Scanner must keep the newline sequences to read a quoted `\r' as is):
+//
+// tw := iosupport.NewTsvWriter(file, ',')
+// tw.Newline = []byte("\r\n")
+// tw.WriteRow([][]byte{[]byte("c1"), []byte("c,2")})
+// tw.Flush()
+//
+// If AlwaysQuote is true, all the fields are quoted.
+//
+// If Comment is not 0, a first field beginning with the Comment character is quoted.
+//
+// If Escape is not 0, the escape character dialect of TsvParser is written instead of RFC 4180 quoting.
+// The tabs, newlines, escape characters and separators are escaped and a nil field is written as a `\N' NULL field.
+type TsvWriter struct {
+	w           *bufio.Writer
+	Separator   byte
+	QuoteChar   byte
+	Comment     byte   // comment character (0 means no comment)
+	Escape      byte   // escape character (0 means RFC 4180 quoting)
+	AlwaysQuote bool   // quote all the fields
+	Newline     []byte // newline sequence written after each row
+	separator   []byte // for internal purpose (see SetSeparator function)
+}
+
+// NewTsvWriter instanciates a new TsvWriter.
+func NewTsvWriter(w io.Writer, separator byte) *TsvWriter {
+	return &TsvWriter{
+		w:         bufio.NewWriter(w),
+		Separator: separator,
+		QuoteChar: '"',
+		Newline:   []byte{LF},
+		separator: []byte{separator},
+	}
+}
+
+// SetSeparator defines a separator made of one or several bytes.
+// The Separator attribute is then the first byte of the separator. An empty separator is ignored.
+func (tw *TsvWriter) SetSeparator(separator []byte) {
+	if len(separator) == 0 {
+		return
+	}
+	tw.Separator = separator[0]
+	tw.separator = separator
+}
+
+// WriteRow writes a single row to w along with the newline sequence.
+// Writes are buffered, so Flush must be called to ensure that the row is written to the underlying io.Writer.
+func (tw *TsvWriter) WriteRow(row [][]byte) error {
+	if len(tw.separator) == 0 || tw.separator[0] != tw.Separator {
+		// The Separator attribute has been modified
+		tw.separator = []byte{tw.Separator}
+	}
+
+	for i, field := range row {
+		if i > 0 {
+			if _, err := tw.w.Write(tw.separator); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		switch {
+		case tw.Escape != 0:
+			err = tw.writeEscapedField(field, i == 0)
+		case tw.AlwaysQuote || tw.fieldNeedsQuotes(field, i == 0):
+			err = tw.writeQuotedField(field)
+		default:
+			_, err = tw.w.Write(field)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := tw.w.Write(tw.Newline)
+	return err
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (tw *TsvWriter) Flush() error {
+	return tw.w.Flush()
+}
+
+// fieldNeedsQuotes reports whether the field must be quoted to be read back by a TsvParser.
+func (tw *TsvWriter) fieldNeedsQuotes(field []byte, first bool) bool {
+	if len(field) == 0 {
+		return false
+	}
+	if first && tw.Comment != 0 && field[0] == tw.Comment {
+		return true
+	}
+
+	return bytes.IndexByte(field, tw.QuoteChar) >= 0 ||
+		bytes.Contains(field, tw.separator) || overlaps(field, tw.separator) ||
+		bytes.IndexByte(field, LF) >= 0 ||
+		bytes.IndexByte(field, CR) >= 0 ||
+		(len(tw.Newline) > 0 && (bytes.Contains(field, tw.Newline) || overlaps(field, tw.Newline)))
+}
+
+// overlaps reports whether the field ends with the beginning of the given sequence of several bytes.
+// The sequence that follows the field would then be found inside the field (e.g. `a|' followed by `||').
+func overlaps(field, seq []byte) bool {
+	for n := 1; n < len(seq) && n <= len(field); n++ {
+		if bytes.HasSuffix(field, seq[:n]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tw *TsvWriter) writeQuotedField(field []byte) error {
+	if err := tw.w.WriteByte(tw.QuoteChar); err != nil {
+		return err
+	}
+
+	for len(field) > 0 {
+		// Quotes are doubled
+		i := bytes.IndexByte(field, tw.QuoteChar)
+		if i < 0 {
+			i = len(field)
+		} else {
+			i++
+		}
+		if _, err := tw.w.Write(field[:i]); err != nil {
+			return err
+		}
+		if field[i-1] == tw.QuoteChar {
+			if err := tw.w.WriteByte(tw.QuoteChar); err != nil {
+				return err
+			}
+		}
+		field = field[i:]
+	}
+
+	return tw.w.WriteByte(tw.QuoteChar)
+}
+
+func (tw *TsvWriter) writeEscapedField(field []byte, first bool) error {
+	if field == nil {
+		_, err := tw.w.Write([]byte{tw.Escape, 'N'}) // NULL
+		return err
+	}
+
+	for i, b := range field {
+		e, ok := escapeByte(b)
+		if !ok && tw.isEscaped(field, i, first) {
+			ok = true // Escaped as itself
+		}
+
+		if ok {
+			if err := tw.w.WriteByte(tw.Escape); err != nil {
+				return err
+			}
+			b = e
+		}
+		if err := tw.w.WriteByte(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isEscaped reports whether the ith byte of the field must be escaped to be read back by a TsvParser.
+func (tw *TsvWriter) isEscaped(field []byte, i int, first bool) bool {
+	b := field[i]
+	switch {
+	case b == tw.Escape:
+		return true
+	case b == tw.separator[0]:
+		// The separator starts in the field, the end of the field may be followed by the rest of the separator
+		return bytes.HasPrefix(field[i:], tw.separator) || bytes.HasPrefix(tw.separator, field[i:])
+	case len(tw.Newline) > 0 && b == tw.Newline[0]:
+		return bytes.HasPrefix(field[i:], tw.Newline) || bytes.HasPrefix(tw.Newline, field[i:])
+	}
+	return i == 0 && first && tw.Comment != 0 && b == tw.Comment
+}
+
+// escapeByte returns the byte written after an escape character for the given character (see unescapeByte).
+func escapeByte(b byte) (byte, bool) {
+	switch b {
+	case 0:
+		return '0', true
+	case '\n':
+		return 'n', true
+	case '\r':
+		return 'r', true
+	case '\t':
+		return 't', true
+	}
+	return b, false
+}
@@ -0,0 +1,188 @@
+package iosupport_test
+
+import (
+	"fmt"
+
+	. "github.com/mdouchement/iosupport"
+	"github.com/mdouchement/stringio"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TsvWriter", func() {
+	var rows = [][][]byte{
+		{[]byte("c1"), []byte("c,2"), []byte(`c"3"`)},
+		{[]byte("multi\nline"), []byte(""), []byte("cr\r")},
+		{[]byte("#v1"), []byte("v2"), []byte("")},
+	}
+
+	// readRows reads back the written TSV
+	var readRows = func(data string, setup func(*TsvParser)) [][][]byte {
+		var sc = NewScanner(stringio.NewFromString(data))
+		sc.KeepNewlineSequence(true) // Keeps the CR of the fields
+		var subject = NewTsvParser(sc, ',')
+		setup(subject)
+		var actual = [][][]byte{}
+
+		for subject.ScanRow() {
+			check(subject.Err())
+			row := [][]byte{}
+			for _, field := range subject.Row() {
+				if field != nil {
+					field = append([]byte{}, field...)
+				}
+				row = append(row, field)
+			}
+			actual = append(actual, row)
+		}
+		check(subject.Err())
+		return actual
+	}
+
+	Describe("#WriteRow", func() {
+		Context("with default settings", func() {
+			var output = stringio.New()
+			var subject = NewTsvWriter(output, ',')
+			subject.Comment = '#'
+
+			for _, row := range rows {
+				check(subject.WriteRow(row))
+			}
+			check(subject.Flush())
+
+			It("quotes the fields only when it is needed", func() {
+				Expect(output.GetValueString()).To(Equal("c1,\"c,2\",\"c\"\"3\"\"\"\n\"multi\nline\",,\"cr\r\"\n\"#v1\",v2,\n"))
+			})
+
+			It("round-trips through TsvParser", func() {
+				Expect(readRows(output.GetValueString(), func(tp *TsvParser) { tp.Comment = '#' })).To(Equal(rows))
+			})
+		})
+
+		Context("with always-quote mode and a newline sequence", func() {
+			var output = stringio.New()
+			var subject = NewTsvWriter(output, ',')
+			subject.AlwaysQuote = true
+			subject.Newline = []byte("\r\n")
+
+			for _, row := range rows {
+				check(subject.WriteRow(row))
+			}
+			check(subject.Flush())
+
+			It("quotes all the fields", func() {
+				Expect(output.GetValueString()).To(HavePrefix("\"c1\",\"c,2\",\"c\"\"3\"\"\"\r\n"))
+			})
+
+			It("round-trips through TsvParser", func() {
+				Expect(readRows(output.GetValueString(), func(tp *TsvParser) {})).To(Equal(rows))
+			})
+		})
+
+		Context("with a separator made of several bytes", func() {
+			var output = stringio.New()
+			var subject = NewTsvWriter(output, ',')
+			subject.SetSeparator([]byte("||"))
+
+			check(subject.WriteRow([][]byte{[]byte("a|b"), []byte("c||d")}))
+			check(subject.Flush())
+
+			It("quotes the fields containing the separator", func() {
+				Expect(output.GetValueString()).To(Equal("a|b||\"c||d\"\n"))
+			})
+
+			It("round-trips through TsvParser", func() {
+				Expect(readRows(output.GetValueString(), func(tp *TsvParser) { tp.SetSeparator([]byte("||")) })).
+					To(Equal([][][]byte{{[]byte("a|b"), []byte("c||d")}}))
+			})
+		})
+
+		Context("with a separator made of several bytes that overlaps the fields", func() {
+			var rows = [][][]byte{
+				{[]byte("|"), []byte("")},
+				{[]byte(",a"), []byte("\\|"), []byte(",\r")},
+				{[]byte("a|"), []byte("|b"), []byte("|,|")},
+			}
+
+			for _, separator := range []string{"||", "|,", ",|"} {
+				separator := separator
+
+				It(fmt.Sprintf("round-trips %q through TsvParser with quotes", separator), func() {
+					var output = stringio.New()
+					var subject = NewTsvWriter(output, ',')
+					subject.SetSeparator([]byte(separator))
+					for _, row := range rows {
+						check(subject.WriteRow(row))
+					}
+					check(subject.Flush())
+
+					Expect(readRows(output.GetValueString(), func(tp *TsvParser) { tp.SetSeparator([]byte(separator)) })).
+						To(Equal(rows))
+				})
+
+				It(fmt.Sprintf("round-trips %q through TsvParser with escapes", separator), func() {
+					var output = stringio.New()
+					var subject = NewTsvWriter(output, ',')
+					subject.SetSeparator([]byte(separator))
+					subject.Escape = '\\'
+					for _, row := range rows {
+						check(subject.WriteRow(row))
+					}
+					check(subject.Flush())
+
+					Expect(readRows(output.GetValueString(), func(tp *TsvParser) {
+						tp.SetSeparator([]byte(separator))
+						tp.Escape = '\\'
+					})).To(Equal(rows))
+				})
+			}
+
+			It("keeps the current separator when the given one is empty", func() {
+				var output = stringio.New()
+				var subject = NewTsvWriter(output, ';')
+				subject.SetSeparator(nil)
+				check(subject.WriteRow([][]byte{[]byte("a"), []byte("b")}))
+				check(subject.Flush())
+
+				Expect(output.GetValueString()).To(Equal("a;b\n"))
+			})
+
+			It("quotes only the fields that overlap the separator", func() {
+				var output = stringio.New()
+				var subject = NewTsvWriter(output, ',')
+				subject.SetSeparator([]byte("||"))
+				check(subject.WriteRow([][]byte{[]byte("a|"), []byte("|b"), []byte("")}))
+				check(subject.Flush())
+
+				Expect(output.GetValueString()).To(Equal("\"a|\"|||b||\n"))
+			})
+		})
+
+		Context("with the escape character dialect", func() {
+			var output = stringio.New()
+			var subject = NewTsvWriter(output, '\t')
+			subject.Escape = '\\'
+			var rows = [][][]byte{
+				{[]byte("a\tb"), nil, []byte(`c\N`)},
+				{[]byte("multi\nline"), []byte(`"quoted"`), []byte("")},
+			}
+
+			for _, row := range rows {
+				check(subject.WriteRow(row))
+			}
+			check(subject.Flush())
+
+			It("escapes the fields", func() {
+				Expect(output.GetValueString()).To(Equal("a\\tb\t\\N\tc\\\\N\nmulti\\nline\t\"quoted\"\t\n"))
+			})
+
+			It("round-trips through TsvParser", func() {
+				Expect(readRows(output.GetValueString(), func(tp *TsvParser) {
+					tp.SetSeparator([]byte{'\t'})
+					tp.Escape = '\\'
+				})).To(Equal(rows))
+			})
+		})
+	})
+})
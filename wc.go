@@ -52,6 +52,7 @@ type (
 		CountChar bool
 		CountWord bool
 		CountLine bool
+		Delimiter Delimiter // Line terminator (AnyNewline by default)
 	}
 )
 
@@ -84,6 +85,7 @@ func defaultWordCounterOptions() *WordCountOptions {
 // Perform starts the count
 func (wc *WordCount) Perform() error {
 	wc.s.KeepNewlineSequence(true)
+	wc.s.SetDelimiter(wc.Opts.Delimiter)
 	for wc.s.ScanLine() {
 		if wc.s.Err() != nil {
 			return wc.s.Err()
@@ -96,7 +98,7 @@ func (wc *WordCount) Perform() error {
 			wc.Chars += len([]rune(wc.s.Text()))
 		}
 		if wc.Opts.CountWord {
-			wc.Words += CountWords(wc.s.Text())
+			wc.Words += CountWords(string(wc.s.trimDelimiter(wc.s.Bytes())))
 		}
 		if wc.Opts.CountLine {
 			wc.Lines++
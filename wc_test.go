@@ -62,5 +62,24 @@ var _ = Describe("WC", func() {
 				Expect(subject.Words).To(Equal(0))
 			})
 		})
+
+		Context("with a delimiter", func() {
+			var subject = NewWordCount(stringio.NewFromString("word1\rword2\x00word3 word4\x00"))
+			opts := NewWordCountOptions()
+			opts.CountWord = true
+			opts.CountLine = true
+			opts.Delimiter = NULDelimiter
+			subject.Opts = opts
+			err := subject.Perform()
+			check(err)
+
+			It("counts the words", func() {
+				Expect(subject.Words).To(Equal(3))
+			})
+
+			It("counts the delimited lines", func() {
+				Expect(subject.Lines).To(Equal(2))
+			})
+		})
 	})
 })